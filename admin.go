@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rows per page in the admin console
+const adminPageSize = 50
+
+// the console's tables in tab order
+var adminTables = []string{"users", "posts", "comments", "reports"}
+
+// the columns each table sorts by, created is the default
+var adminSortColumns = map[string]map[string]string{
+	"users": {"created": "created_at", "name": "name", "handle": "handle"},
+	"posts": {"created": "created_at", "title": "title", "weight": "weight", "likes": "likes", "comments": "comments"},
+	"comments": {"created": "created_at", "user": "user_name"},
+	"reports": {"created": "created_at", "category": "category", "type": "target_type"},
+}
+
+// what the status filter offers for each table
+var adminStatuses = map[string][]string{
+	"users": {"active", "deleted", "suspended", "banned", "shadowbanned", "moderator"},
+	"posts": {"active", "deleted", "held", "hidden"},
+	"comments": {"active", "deleted", "held", "hidden"},
+	"reports": {"open", "resolved"},
+}
+
+const adminDateLayout = "2006-01-02"
+
+// Reads the console's query string, anything unknown falls back to the newest users
+func parseAdminSearch(query url.Values) AdminSearch {
+	search := AdminSearch{
+		Table: query.Get("table"),
+		Query: strings.TrimSpace(query.Get("q")),
+		User: strings.TrimSpace(query.Get("user")),
+		Status: query.Get("status"),
+		Sort: query.Get("sort"),
+		Desc: query.Get("dir") != "asc",
+	}
+
+	if _, ok := adminSortColumns[search.Table]; !ok {
+		search.Table = "users"
+	}
+	if _, ok := adminSortColumns[search.Table][search.Sort]; !ok {
+		search.Sort = "created"
+	}
+
+	known := false
+	for _, status := range adminStatuses[search.Table] {
+		known = known || status == search.Status
+	}
+	if !known {
+		search.Status = ""
+	}
+
+	search.From, _ = time.Parse(adminDateLayout, query.Get("from"))
+	search.To, _ = time.Parse(adminDateLayout, query.Get("to"))
+
+	search.Page, _ = strconv.Atoi(query.Get("page"))
+	if search.Page < 0 {
+		search.Page = 0
+	}
+
+	return search
+}
+
+func (s AdminSearch) values() url.Values {
+	values := url.Values{"table": {s.Table}}
+	if s.Query != "" {
+		values.Set("q", s.Query)
+	}
+	if s.User != "" {
+		values.Set("user", s.User)
+	}
+	if s.Status != "" {
+		values.Set("status", s.Status)
+	}
+	if !s.From.IsZero() {
+		values.Set("from", s.FromDate())
+	}
+	if !s.To.IsZero() {
+		values.Set("to", s.ToDate())
+	}
+	values.Set("sort", s.Sort)
+	if !s.Desc {
+		values.Set("dir", "asc")
+	}
+	if s.Page > 0 {
+		values.Set("page", strconv.Itoa(s.Page))
+	}
+
+	return values
+}
+
+func (s AdminSearch) URL() string {
+	return "/admin?" + s.values().Encode()
+}
+
+// Sorting by the current column flips it, any other starts descending, either way back to the first page
+func (s AdminSearch) SortURL(Sort string) string {
+	s.Desc = s.Sort != Sort || !s.Desc
+	s.Sort = Sort
+	s.Page = 0
+
+	return s.URL()
+}
+
+// Arrow for the column currently sorted by
+func (s AdminSearch) SortMark(Sort string) string {
+	if s.Sort != Sort {
+		return ""
+	}
+	if s.Desc {
+		return "▼"
+	}
+
+	return "▲"
+}
+
+func (s AdminSearch) PageURL(Page int) string {
+	s.Page = Page
+
+	return s.URL()
+}
+
+// Same filters on another table
+func (s AdminSearch) TableURL(Table string) string {
+	return AdminSearch{Table: Table, User: s.User, From: s.From, To: s.To, Sort: "created", Desc: true}.URL()
+}
+
+func (s AdminSearch) FromDate() string {
+	if s.From.IsZero() {
+		return ""
+	}
+
+	return s.From.Format(adminDateLayout)
+}
+
+func (s AdminSearch) ToDate() string {
+	if s.To.IsZero() {
+		return ""
+	}
+
+	return s.To.Format(adminDateLayout)
+}
+
+func (s AdminSearch) order() string {
+	order := adminSortColumns[s.Table][s.Sort]
+	if s.Desc {
+		return order + " DESC, rowid DESC"
+	}
+
+	return order + " ASC, rowid ASC"
+}
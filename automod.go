@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// what a rule checks for
+var automodKinds = []struct {
+	Kind string
+	Label string
+}{
+	{"words", "Word or phrase list"},
+	{"regex", "Regular expression"},
+	{"links", "More links than the threshold"},
+	{"age", "Account younger than the threshold in hours"},
+	{"rate", "More submissions than the threshold in the window"},
+}
+
+// what a rule does with what it catches, strongest first so the strongest match wins
+var automodActions = []struct {
+	Action string
+	Label string
+}{
+	{"reject", "Reject"},
+	{"hold", "Hold for review"},
+	{"hide", "Shadow-hide"},
+	{"flag", "Flag for moderators"},
+}
+
+// the Automod column each withholding action leaves on the post or comment
+var automodWithheld = map[string]string{
+	"hold": "held",
+	"hide": "hidden",
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// how much of a submission is kept with each hit
+const automodExcerptLength = 200
+
+func automodRank(Action string) int {
+	for i, action := range automodActions {
+		if action.Action == Action {
+			return i
+		}
+	}
+
+	return len(automodActions)
+}
+
+// One case insensitive expression matching any listed word or phrase on its own
+func wordPattern(Pattern string) (*regexp.Regexp, error) {
+	var words []string
+	for _, line := range strings.Split(Pattern, "\n") {
+		if word := strings.TrimSpace(line); word != "" {
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	if len(words) == 0 {
+		return nil, errors.New("list at least one word")
+	}
+
+	return regexp.Compile(`(?i)\b(?:` + strings.Join(words, "|") + `)\b`)
+}
+
+// Checks a rule can run before it's saved
+func (rule AutomodRule) validate() error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return errors.New("give the rule a name")
+	}
+	if automodRank(rule.Action) == len(automodActions) {
+		return errors.New("unknown action " + rule.Action)
+	}
+	if rule.Applies != "" && rule.Applies != "post" && rule.Applies != "comment" {
+		return errors.New("rules apply to posts, comments or both")
+	}
+
+	switch rule.Kind {
+	case "words":
+		_, err := wordPattern(rule.Pattern)
+		return err
+	case "regex":
+		_, err := regexp.Compile(rule.Pattern)
+		return err
+	case "links":
+		if rule.Threshold < 0 {
+			return errors.New("the link limit can't be negative")
+		}
+	case "age":
+		if rule.Threshold < 1 {
+			return errors.New("the account age threshold is at least an hour")
+		}
+	case "rate":
+		if rule.Threshold < 1 || rule.Window < 1 {
+			return errors.New("rate rules need a threshold and a window of at least one")
+		}
+	default:
+		return errors.New("unknown kind " + rule.Kind)
+	}
+
+	return nil
+}
+
+// Whether the rule catches Text from author
+func (a App) automodMatches(rule AutomodRule, TargetType string, author User, Text string) (bool, error) {
+	switch rule.Kind {
+	case "words":
+		pattern, err := wordPattern(rule.Pattern)
+		if err != nil {
+			return false, err
+		}
+		return pattern.MatchString(Text), nil
+	case "regex":
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return false, err
+		}
+		return pattern.MatchString(Text), nil
+	case "links":
+		return len(linkPattern.FindAllString(Text, -1)) > rule.Threshold, nil
+	case "age":
+		return time.Since(author.CreatedAt) < time.Duration(rule.Threshold) * time.Hour, nil
+	case "rate":
+		count, err := a.countSubmissionsSince(author, TargetType, time.Now().Add(-time.Duration(rule.Window) * time.Minute))
+		return count >= int64(rule.Threshold), err
+	}
+
+	return false, errors.New("unknown kind " + rule.Kind)
+}
+
+// Runs the enabled rules for TargetType over a submission before it's saved
+//
+// returns the strongest action among the matches, "" when nothing matched, and the matches for logAutomod.
+// A rule that can't run is skipped rather than blocking everyone
+func (a App) automod(TargetType string, author User, Text string) (string, []AutomodRule) {
+	rules, err := a.getAutomodRules(TargetType)
+	if err != nil {
+		fmt.Println("Failed to load automod rules:", err)
+		return "", nil
+	}
+
+	action := ""
+	matched := make([]AutomodRule, 0)
+	for _, rule := range rules {
+		match, err := a.automodMatches(rule, TargetType, author, Text)
+		if err != nil {
+			fmt.Println("Automod rule", rule.Name, "failed:", err)
+			continue
+		}
+		if !match {
+			continue
+		}
+
+		matched = append(matched, rule)
+		if action == "" || automodRank(rule.Action) < automodRank(action) {
+			action = rule.Action
+		}
+	}
+
+	return action, matched
+}
+
+// Logs every match against what came of the submission, TargetUUID is empty when it was rejected
+//
+// flagging rules also put what was saved in the moderation queue
+func (a App) logAutomod(matched []AutomodRule, TargetType string, TargetUUID string, author User, Text string) {
+	excerpt := []rune(Text)
+	if len(excerpt) > automodExcerptLength {
+		excerpt = excerpt[:automodExcerptLength]
+	}
+
+	for _, rule := range matched {
+		_, err := a.recordAutomodHit(AutomodHit{
+			RuleUUID: rule.UUID,
+			RuleName: rule.Name,
+			Action: rule.Action,
+			TargetType: TargetType,
+			TargetUUID: TargetUUID,
+			UserUUID: author.UUID,
+			UserName: author.Name,
+			Excerpt: string(excerpt),
+		})
+		if err != nil {
+			fmt.Println("Failed to log automod hit", rule.Name, err)
+		}
+
+		if rule.Action == "flag" && TargetUUID != "" {
+			// no reporter, so nobody is told the outcome
+			_, err = a.report(Report{
+				TargetType: TargetType,
+				TargetUUID: TargetUUID,
+				Category: "other",
+				Details: "Automod: " + rule.Name,
+			})
+			if err != nil {
+				fmt.Println("Failed to flag", TargetType, TargetUUID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// how a challenge ranks entrants, Expr is summed or maxed over their entries per user
+//
+// reps and sets of 0 are posts from before we asked, they count as a single rep
+var challengeScoring = []struct {
+	Type string
+	Label string
+	Unit string
+	Expr string
+}{
+	{"max", "Heaviest single lift", "lbs", "MAX(weight)"},
+	{"volume", "Total volume (weight x reps x sets)", "lbs", "SUM(weight * max(reps, 1) * max(sets, 1))"},
+	{"reps", "Total reps", "reps", "SUM(max(reps, 1) * max(sets, 1))"},
+	{"points", "One point per entry", "points", "COUNT(*)"},
+}
+
+// who challenge results come from, so the creator hears about a challenge they entered themselves
+var challengeResults = User{Name: "Flexlift"}
+
+func scoringExpr(Scoring string) (string, bool) {
+	for _, scoring := range challengeScoring {
+		if scoring.Type == Scoring {
+			return scoring.Expr, true
+		}
+	}
+	return "", false
+}
+
+// upcoming, active or ended, ended challenges stay "ended" until the closer gets to them
+func (c Challenge) Status() string {
+	now := time.Now()
+
+	switch {
+	case c.Closed:
+		return "closed"
+	case now.Before(c.StartsAt):
+		return "upcoming"
+	case now.Before(c.EndsAt):
+		return "active"
+	default:
+		return "ended"
+	}
+}
+
+func (c Challenge) ScoringLabel() string {
+	for _, scoring := range challengeScoring {
+		if scoring.Type == c.Scoring {
+			return scoring.Label
+		}
+	}
+	return c.Scoring
+}
+
+func (c Challenge) Unit() string {
+	for _, scoring := range challengeScoring {
+		if scoring.Type == c.Scoring {
+			return scoring.Unit
+		}
+	}
+	return ""
+}
+
+// 1st, 2nd, 3rd for badges and standings
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// Finalises every challenge past its end, hands out badges and tells the entrants
+func (a App) closeChallenges() error {
+	challenges, err := a.getEndedChallenges(time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, challenge := range challenges {
+		standings, err := a.getStandings(challenge, User{}, -1)
+		if err != nil {
+			return err
+		}
+
+		err = a.closeChallenge(challenge, standings)
+		if err != nil {
+			return err
+		}
+
+		for _, standing := range standings {
+			err = a.notify(Notification{
+				UserUUID: standing.UserUUID,
+				Type: "challenge",
+				ActorUUID: challengeResults.UUID,
+				ActorName: challengeResults.Name,
+				TargetUUID: challenge.UUID,
+			})
+			if err != nil {
+				fmt.Println("Failed to send challenge notification")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Runs closeChallenges on an interval for the life of the server
+func (a App) challengeCloser(interval time.Duration) {
+	for {
+		if err := a.closeChallenges(); err != nil {
+			fmt.Println("Closing challenges failed:", err)
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -3,25 +3,28 @@ package main
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Returns created user's UUID
-func (a App) createUser(user User, Password string) (string, error) {
+func (a App) createUser(user User, Email string, Password string) (string, error) {
 	id := uuid.New()
 	user.UUID = id.String()
 
-	err := a.DB.Table("Users").Create(user).Error
+	err := a.DB.Table("Users").Create(&user).Error
 	if err != nil {
 		return "", err
 	}
 	err = nil
 
-	err = a.DB.Table("Auth").Create(Auth{UserUUID: user.UUID, Password: Password}).Error
+	err = a.DB.Table("Auth").Create(Auth{UserUUID: user.UUID, Email: Email, Password: Password}).Error
 	if err != nil {
 		return "", err
 	}
@@ -33,7 +36,7 @@ func (a App) createUser(user User, Password string) (string, error) {
 func (a App) getUserByHandle(Handle string) (User, error) {
 	var user User
 
-	err := a.DB.Table("Users").First(&user, "handle = ?", Handle).Error
+	err := a.DB.Table("Users").First(&user, "handle = ? AND deleted = ?", Handle, false).Error
 
 	return user, err
 
@@ -43,202 +46,2584 @@ func (a App) getUserByHandle(Handle string) (User, error) {
 func (a App) getUserByUUID(UUID string) (User, error) {
 	var user User
 
-	err := a.DB.Table("Users").First(&user, "UUID = ?", UUID).Error
+	err := a.DB.Table("Users").First(&user, "UUID = ? AND deleted = ?", UUID, false).Error
 
 	return user, err
 }
 
-//Returns list of users
-func (a App) getUsers(Limit int, Offset int) ([]User, error) {
+// Finds a deleted account by UUID or handle, for restoring it
+func (a App) getDeletedUser(Key string) (User, error) {
+	var user User
+
+	err := a.DB.Table("Users").First(&user, "(uuid = ? OR handle = ?) AND deleted = ?", Key, Key, true).Error
+
+	return user, err
+}
+
+// Whether anyone has the handle, deleted accounts keep theirs until they're purged
+func (a App) handleTaken(Handle string) (bool, error) {
+	var count int64
+
+	err := a.DB.Table("Users").Where("handle = ?", Handle).Count(&count).Error
+
+	return count > 0, err
+}
+
+// UUIDs of deleted accounts, as a subquery for NOT IN
+func (a App) deletedUsers() *gorm.DB {
+	return a.DB.Table("Users").Select("uuid").Where("deleted = ?", true)
+}
+
+// Hides the user and signs them out everywhere, by is whoever did it so only they or a moderator can undo it
+func (a App) deleteUser(user User, by User) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Users").Where("uuid = ?", user.UUID).Updates(map[string]interface{}{
+			"deleted": true,
+			"deleted_at": time.Now(),
+			"deleted_by": by.UUID,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Auth").Where("user_uuid = ?", user.UUID).Update("current_cookie", gorm.Expr("NULL")).Error
+	})
+}
+
+func (a App) restoreUser(user User) error {
+	return a.DB.Table("Users").Where("uuid = ?", user.UUID).Updates(map[string]interface{}{
+		"deleted": false,
+		"deleted_by": "",
+	}).Error
+}
+
+// Removes the user and everything that hangs off them for good, run by purgeDeleted once they can't be restored
+func (a App) purgeUser(user User) error {
+	var posts []Post
+	err := a.DB.Table("Posts").Where("user_uuid = ?", user.UUID).Find(&posts).Error
+	if err != nil {
+		return err
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		t := a
+		t.DB = tx
+		return t.purgeUserRows(user, posts)
+	})
+	if err != nil {
+		return err
+	}
+
+	// released once the rows are gone, so a purge retried after a failure can't release twice
+	for _, post := range posts {
+		err = releaseMedia(a.DB, post.MediaHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a App) purgeUserRows(user User, posts []Post) error {
+	var comments []Comment
+	err := a.DB.Table("Comments").Where("user_uuid = ?", user.UUID).Find(&comments).Error
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		err = a.deleteComment(comment, user)
+		if err != nil {
+			return err
+		}
+		comment.Deleted = true
+		err = a.purgeComment(comment)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, post := range posts {
+		err = a.purgePostRows(post)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = a.DB.Table("Users").Where("uuid = ?", user.UUID).Delete(&User{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Auth").Where("user_uuid = ?", user.UUID).Delete(&Auth{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Follows").Where("follower_uuid = ? OR followee_uuid = ?", user.UUID, user.UUID).Delete(&Follow{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Blocks").Where("user_uuid = ? OR blocked_uuid = ?", user.UUID, user.UUID).Delete(&Block{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Notifications").Where("user_uuid = ? OR actor_uuid = ?", user.UUID, user.UUID).Delete(&Notification{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("NotificationPreferences").Where("user_uuid = ?", user.UUID).Delete(&NotificationPreference{}).Error
+	if err != nil {
+		return err
+	}
+	// bans outlive the account so its email stays blocked
+	err = a.DB.Table("Suspensions").Where("user_uuid = ? AND kind <> ?", user.UUID, "ban").Delete(&Suspension{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("ConversationMembers").Where("user_uuid = ?", user.UUID).Delete(&ConversationMember{}).Error
+	if err != nil {
+		return err
+	}
+	memberships := a.DB.Table("GymMembers").Select("gym_uuid").Where("user_uuid = ? AND approved = ?", user.UUID, true)
+	err = a.DB.Table("Gyms").Where("uuid IN (?)", memberships).UpdateColumn("members", gorm.Expr("members - ?", 1)).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("GymMembers").Where("user_uuid = ?", user.UUID).Delete(&GymMember{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("VerificationVotes").Where("user_uuid = ?", user.UUID).Delete(&VerificationVote{}).Error
+	if err != nil {
+		return err
+	}
+	// lights already counted stay on the lift, only open seats are given up
+	err = a.DB.Table("Judgements").Where("judge_uuid = ? AND light = ''", user.UUID).Delete(&Judgement{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Bookmarks").Where("user_uuid = ?", user.UUID).Delete(&Bookmark{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Collections").Where("user_uuid = ?", user.UUID).Delete(&Collection{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Badges").Where("user_uuid = ?", user.UUID).Delete(&Badge{}).Error
+	if err != nil {
+		return err
+	}
+	return a.DB.Table("Mentions").Where("user_uuid = ?", user.UUID).Delete(&Mention{}).Error
+}
+
+// Returns the post UUID
+//
+// takes a reference on post.MediaHash in the same transaction
+func (a App) createPost(post Post) (string, error) {
+	id := uuid.New()
+	post.UUID = id.String()
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Posts").Create(&post).Error
+		if err != nil {
+			return err
+		}
+
+		return retainMedia(tx, post.MediaHash, post.MediaType)
+	})
+
+	return post.UUID, err
+}
+
+// Hides the post, by is whoever did it so only they or a moderator can undo it
+func (a App) deletePost(post Post, by User) error {
+	return a.DB.Table("Posts").Where("uuid = ?", post.UUID).Updates(map[string]interface{}{
+		"deleted": true,
+		"deleted_at": time.Now(),
+		"deleted_by": by.UUID,
+	}).Error
+}
+
+func (a App) restorePost(post Post) error {
+	return a.DB.Table("Posts").Where("uuid = ?", post.UUID).Updates(map[string]interface{}{
+		"deleted": false,
+		"deleted_by": "",
+	}).Error
+}
+
+// Removes the post, its rows and its reference on the media for good, run by purgeDeleted
+func (a App) purgePost(post Post) error {
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		t := a
+		t.DB = tx
+		return t.purgePostRows(post)
+	})
+	if err != nil {
+		return err
+	}
+
+	// released once the rows are gone, so a purge retried after a failure can't release twice
+	return releaseMedia(a.DB, post.MediaHash)
+}
+
+func (a App) purgePostRows(post Post) error {
+	err := a.DB.Table("Posts").Where("uuid = ?", post.UUID).Delete(&Post{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Comments").Where("post_uuid = ?", post.UUID).Delete(&Comment{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Revisions").Where("target_uuid = ?", post.UUID).Delete(&Revision{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Likes").Where("post_uuid = ?", post.UUID).Delete(&Like{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("VerificationVotes").Where("post_uuid = ?", post.UUID).Delete(&VerificationVote{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Judgements").Where("post_uuid = ?", post.UUID).Delete(&Judgement{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Bookmarks").Where("post_uuid = ?", post.UUID).Delete(&Bookmark{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("DuplicateFlags").Where("post_uuid = ? OR match_uuid = ?", post.UUID, post.UUID).Delete(&DuplicateFlag{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Notifications").Where("post_uuid = ?", post.UUID).Delete(&Notification{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Mentions").Where("post_uuid = ?", post.UUID).Delete(&Mention{}).Error
+	if err != nil {
+		return err
+	}
+	return a.DB.Table("Tags").Where("post_uuid = ?", post.UUID).Delete(&Tag{}).Error
+}
+
+// Media refcounting, blobs themselves live on disk and are removed by collectMedia
+func retainMedia(tx *gorm.DB, Hash string, MediaType string) error {
+	if Hash == "" {
+		return nil
+	}
+
+	return tx.Table("Media").Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+	}).Create(&Media{Hash: Hash, MediaType: MediaType, RefCount: 1}).Error
+}
+
+func releaseMedia(tx *gorm.DB, Hash string) error {
+	if Hash == "" {
+		return nil
+	}
+
+	return tx.Table("Media").Where("hash = ?", Hash).Updates(map[string]interface{}{
+		"ref_count": gorm.Expr("ref_count - 1"),
+		"orphaned_at": time.Now(),
+	}).Error
+}
+
+// Points a post without media at a stored blob, used when migrating old uploads
+func (a App) attachMedia(post Post) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Posts").Where("uuid = ?", post.UUID).Updates(map[string]interface{}{
+			"media_hash": post.MediaHash,
+			"media_type": post.MediaType,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return retainMedia(tx, post.MediaHash, post.MediaType)
+	})
+}
+
+// Blobs nobody has referenced since before cutoff
+func (a App) getOrphanedMedia(cutoff time.Time) ([]Media, error) {
+	var media []Media
+
+	err := a.DB.Table("Media").Where("ref_count <= 0 AND orphaned_at < ?", cutoff).Find(&media).Error
+
+	return media, err
+}
+
+// Drops the row and runs remove on the file in one transaction, false if the blob picked up a reference since it was listed
+//
+// an upload in another process can't save its reference until this commits, and stores the blob again once it has
+func (a App) deleteMedia(media Media, remove func() error) (bool, error) {
+	gone := false
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Table("Media").Where("hash = ? AND ref_count <= 0", media.Hash).Delete(&Media{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		gone = true
+		return remove()
+	})
+
+	return gone && err == nil, err
+}
+
+func (a App) mediaExists(Hash string) (bool, error) {
+	var count int64
+
+	err := a.DB.Table("Media").Where("hash = ?", Hash).Count(&count).Error
+
+	return count > 0, err
+}
+
+// Finds the closest post by another user whose media matches, ok is false if nothing is close enough
+func (a App) findDuplicate(post Post) (Post, int, bool, error) {
+	var candidates []Post
+
+	err := a.DB.Table("Posts").Where("user_uuid <> ? AND uuid <> ? AND deleted = ?", post.UserUUID, post.UUID, false).
+		Where("media_hash = ? OR hash_distance(COALESCE(perceptual_hash, ''), ?) BETWEEN 0 AND ?", post.MediaHash, post.PerceptualHash, duplicateDistance).
+		Find(&candidates).Error
+	if err != nil {
+		return Post{}, 0, false, err
+	}
+
+	var best Post
+	bestDistance := -1
+	for _, candidate := range candidates {
+		distance := hashDistance(post.PerceptualHash, candidate.PerceptualHash)
+		if candidate.MediaHash == post.MediaHash {
+			distance = 0
+		}
+		if distance < 0 || distance > duplicateDistance {
+			continue
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	return best, bestDistance, bestDistance >= 0, nil
+}
+
+func (a App) createDuplicateFlag(flag DuplicateFlag) (string, error) {
+	id := uuid.New()
+	flag.UUID = id.String()
+
+	err := a.DB.Table("DuplicateFlags").Create(&flag).Error
+
+	return flag.UUID, err
+}
+
+// Unresolved flags, oldest first
+func (a App) getDuplicateFlags(Limit int, Offset int) ([]DuplicateFlag, error) {
+	var flags []DuplicateFlag
+
+	err := a.DB.Table("DuplicateFlags").Where("resolved = ?", false).Offset(Offset).Limit(Limit).Find(&flags).Error
+
+	return flags, err
+}
+
+func (a App) resolveDuplicateFlag(UUID string) error {
+	return a.DB.Table("DuplicateFlags").Where("uuid = ?", UUID).Update("resolved", true).Error
+}
+
+// Bans both hashes of a post's media so neither re-uploads nor near copies get through
+func (a App) banMedia(post Post, Reason string, ModeratorUUID string) (string, error) {
+	id := uuid.New()
+
+	ban := BannedMedia{
+		UUID: id.String(),
+		Hash: post.MediaHash,
+		PerceptualHash: post.PerceptualHash,
+		Reason: Reason,
+		ModeratorUUID: ModeratorUUID,
+	}
+
+	err := a.DB.Table("BannedMedia").Create(&ban).Error
+
+	return ban.UUID, err
+}
+
+func (a App) unbanMedia(UUID string) error {
+	return a.DB.Table("BannedMedia").Where("uuid = ?", UUID).Delete(&BannedMedia{}).Error
+}
+
+func (a App) getBannedMedia(Limit int, Offset int) ([]BannedMedia, error) {
+	var bans []BannedMedia
+
+	err := a.DB.Table("BannedMedia").Offset(Offset).Limit(Limit).Find(&bans).Error
+
+	return bans, err
+}
+
+// True if the upload matches a ban exactly or is within duplicateDistance of a banned perceptual hash
+func (a App) isMediaBanned(Hash string, PerceptualHash string) (bool, error) {
+	var count int64
+
+	err := a.DB.Table("BannedMedia").
+		Where("hash = ? OR hash_distance(COALESCE(perceptual_hash, ''), ?) BETWEEN 0 AND ?", Hash, PerceptualHash, duplicateDistance).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// Marks the comment deleted, the text is kept until purgeDeleted so it can be restored
+//
+// threadComments shows it as a [deleted] placeholder while it has replies and drops it otherwise
+func (a App) deleteComment(comment Comment, by User) error {
+	if comment.Deleted {
+		return nil
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Comments").Where("uuid = ?", comment.UUID).Updates(map[string]interface{}{
+			"deleted": true,
+			"deleted_at": time.Now(),
+			"deleted_by": by.UUID,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		// a deleted answer can't resolve anything, the form check goes back to open
+		err = tx.Table("Posts").Where("uuid = ? AND accepted_uuid = ?", comment.PostUUID, comment.UUID).
+			UpdateColumn("accepted_uuid", "").Error
+		if err != nil {
+			return err
+		}
+
+		// withheld comments and a shadowbanned author's were never counted
+		counted, err := countsOnPosts(tx, comment.UserUUID)
+		if err != nil || !counted || comment.Automod != "" {
+			return err
+		}
+
+		return tx.Table("Posts").Where("uuid = ?", comment.PostUUID).UpdateColumn("Comments", gorm.Expr("Comments - ?", 1)).Error
+	})
+}
+
+func (a App) restoreComment(comment Comment) error {
+	if !comment.Deleted {
+		return nil
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Comments").Where("uuid = ?", comment.UUID).Updates(map[string]interface{}{
+			"deleted": false,
+			"deleted_by": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		counted, err := countsOnPosts(tx, comment.UserUUID)
+		if err != nil || !counted || comment.Automod != "" {
+			return err
+		}
+
+		return tx.Table("Posts").Where("uuid = ?", comment.PostUUID).UpdateColumn("Comments", gorm.Expr("Comments + ?", 1)).Error
+	})
+}
+
+// Gets rid of a deleted comment's text for good, it stays as an empty placeholder while it has replies
+func (a App) purgeComment(comment Comment) error {
+	var replies int64
+
+	err := a.DB.Table("Comments").Where("parent_uuid = ?", comment.UUID).Count(&replies).Error
+	if err != nil {
+		return err
+	}
+
+	if replies > 0 {
+		err = a.DB.Table("Comments").Where("uuid = ?", comment.UUID).Update("content", "").Error
+	} else {
+		err = a.DB.Table("Comments").Where("uuid = ?", comment.UUID).Delete(&Comment{}).Error
+		if err == nil {
+			err = a.pruneComment(comment.ParentUUID)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// placeholder or gone, either way its text no longer mentions or tags anything
+	err = a.DB.Table("Mentions").Where("source_uuid = ?", comment.UUID).Delete(&Mention{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Tags").Where("source_uuid = ?", comment.UUID).Delete(&Tag{}).Error
+	if err != nil {
+		return err
+	}
+
+	return a.DB.Table("Revisions").Where("target_uuid = ?", comment.UUID).Delete(&Revision{}).Error
+}
+
+// Removes a purged placeholder once its last reply is gone, walking up the thread
+//
+// deleted parents that still have their text are left for their own turn in purgeDeleted
+func (a App) pruneComment(UUID string) error {
+	for UUID != "" {
+		parent, err := a.getCommentByUUID(UUID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var replies int64
+		err = a.DB.Table("Comments").Where("parent_uuid = ?", parent.UUID).Count(&replies).Error
+		if err != nil {
+			return err
+		}
+		if !parent.Deleted || parent.Content != "" || replies > 0 {
+			return nil
+		}
+
+		err = a.DB.Table("Comments").Where("uuid = ?", parent.UUID).Delete(&Comment{}).Error
+		if err != nil {
+			return err
+		}
+
+		UUID = parent.ParentUUID
+	}
+
+	return nil
+}
+
+// Returns a Post object
+func (a App) getPostByUUID(UUID string) (Post, error) {
+	var post Post
+
+	err := a.DB.Table("Posts").Where("deleted = ? AND user_uuid NOT IN (?)", false, a.deletedUsers()).First(&post, "UUID = ?", UUID).Error
+
+	return post, err
+}
+
+// A deleted post, for its owner or a moderator to look at and restore
+func (a App) getDeletedPost(UUID string) (Post, error) {
+	var post Post
+
+	err := a.DB.Table("Posts").First(&post, "uuid = ? AND deleted = ?", UUID, true).Error
+
+	return post, err
+}
+
+// Deleted posts and comments, newest first, every user's when user is empty
+func (a App) getDeletedPosts(user User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	query := a.DB.Table("Posts").Where("deleted = ?", true)
+	if user.UUID != "" {
+		query = query.Where("user_uuid = ?", user.UUID)
+	}
+
+	err := query.Order("deleted_at DESC").Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+func (a App) getDeletedComments(user User, Limit int, Offset int) ([]Comment, error) {
+	var comments []Comment
+
+	// purged placeholders have nothing left to restore
+	query := a.DB.Table("Comments").Where("deleted = ? AND content <> ''", true)
+	if user.UUID != "" {
+		query = query.Where("user_uuid = ?", user.UUID)
+	}
+
+	err := query.Order("deleted_at DESC").Offset(Offset).Limit(Limit).Find(&comments).Error
+
+	return comments, err
+}
+
+func (a App) getDeletedUsers(Limit int, Offset int) ([]User, error) {
 	var users []User
 
-	err := a.DB.Table("Users").Offset(Offset).Limit(Limit).Find(&users).Error
+	err := a.DB.Table("Users").Where("deleted = ?", true).Order("deleted_at DESC").Offset(Offset).Limit(Limit).Find(&users).Error
+
+	return users, err
+}
+
+//gets the most recent posts from a user
+//
+// empty if viewer has blocked or muted them
+func (a App) getPostsByUser(user User, viewer User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	err := a.DB.Table("Posts").Where("user_uuid = ? AND user_uuid NOT IN (?) AND deleted = ?", user.UUID, a.hiddenUsers(viewer), false).
+		Where("(automod = '' OR user_uuid = ?)", viewer.UUID).
+		Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+// reaction types in the order postcards show them, clap is what old likes became
+var reactionTypes = []struct {
+	Type string
+	Label string
+	Emoji string
+}{
+	{"clap", "Clap", "👏"},
+	{"fire", "Fire", "🔥"},
+	{"strong", "Strong", "💪"},
+	{"formcheck", "Check your form", "🧐"},
+}
+
+// Posts column counting each reaction type
+var reactionColumns = map[string]string{
+	"clap": "claps",
+	"fire": "fires",
+	"strong": "strongs",
+	"formcheck": "form_checks",
+}
+
+//React to a post, replaces any reaction the user already has on it
+func (a App) likePost(post Post, user User, Type string) error {
+	column, ok := reactionColumns[Type]
+	if !ok {
+		return errors.New("unknown reaction " + Type)
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		counted, err := countsOnPosts(tx, user.UUID)
+		if err != nil {
+			return err
+		}
+
+		var has_liked Like
+		err = tx.Table("Likes").First(&has_liked, "post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err := tx.Table("Likes").Create(&Like{UserUUID: user.UUID, PostUUID: post.UUID, Type: Type}).Error
+			if err != nil || !counted {
+				return err
+			}
+
+			return tx.Table("Posts").Where("UUID = ?", post.UUID).Updates(map[string]interface{}{
+				"likes": gorm.Expr("likes + 1"),
+				column: gorm.Expr(column + " + 1"),
+			}).Error
+		} else if err != nil {
+			return err
+		}
+
+		if has_liked.Type == Type {
+			return nil
+		}
+
+		err = tx.Table("Likes").Where("post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Update("type", Type).Error
+		if err != nil || !counted {
+			return err
+		}
+
+		updates := map[string]interface{}{column: gorm.Expr(column + " + 1")}
+		if old, ok := reactionColumns[has_liked.Type]; ok {
+			updates[old] = gorm.Expr(old + " - 1")
+		}
+
+		return tx.Table("Posts").Where("UUID = ?", post.UUID).Updates(updates).Error
+	})
+}
+
+//remove a reaction of any type
+func (a App) removeLike(post Post, user User) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		var has_liked Like
+
+		err := tx.Table("Likes").First(&has_liked, "post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// only the remove that actually took the row takes it off the counts
+		res := tx.Table("Likes").Where("post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Delete(&Like{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		counted, err := countsOnPosts(tx, user.UUID)
+		if err != nil || !counted {
+			return err
+		}
+
+		updates := map[string]interface{}{"likes": gorm.Expr("likes - 1")}
+		if column, ok := reactionColumns[has_liked.Type]; ok {
+			updates[column] = gorm.Expr(column + " - 1")
+		}
+
+		return tx.Table("Posts").Where("UUID = ?", post.UUID).Updates(updates).Error
+	})
+}
+
+// Returns the type of the user's reaction to the post, "" if they haven't reacted
+func (a App) getReaction(post Post, user User) (string, error) {
+	var has_liked Like
+
+	err := a.DB.Table("Likes").First(&has_liked, "post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Error
+	if err == gorm.ErrRecordNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	} else {
+		return has_liked.Type, nil
+	}
+}
+
+// Everyone who reacted to a post, newest first
+func (a App) getReactions(post Post) ([]Like, error) {
+	var likes []Like
+
+	err := a.DB.Table("Likes").Where("post_uuid = ?", post.UUID).Order("created_at DESC").Find(&likes).Error
+
+	return likes, err
+}
+
+// Turns likes from before reaction types into claps and rebuilds every post's counters from Likes
+func (a App) migrateReactions() error {
+	res := a.DB.Table("Likes").Where("type IS NULL OR type = ''").Update("type", "clap")
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+
+	updates := map[string]interface{}{
+		"likes": gorm.Expr("(SELECT COUNT(*) FROM Likes WHERE Likes.post_uuid = Posts.uuid)"),
+	}
+	for Type, column := range reactionColumns {
+		updates[column] = gorm.Expr("(SELECT COUNT(*) FROM Likes WHERE Likes.post_uuid = Posts.uuid AND Likes.type = ?)", Type)
+	}
+
+	return a.DB.Table("Posts").Where("1 = 1").Updates(updates).Error
+}
+
+// Count of one reaction type, for the postcard
+func (p Post) ReactionCount(Type string) int {
+	switch Type {
+	case "clap":
+		return p.Claps
+	case "fire":
+		return p.Fires
+	case "strong":
+		return p.Strongs
+	case "formcheck":
+		return p.FormChecks
+	}
+	return 0
+}
+
+//Follow a user, following twice is a no-op
+func (a App) followUser(follower User, followee User) error {
+	following, err := a.isFollowing(follower, followee)
+	if err != nil || following {
+		return err
+	}
+
+	return a.DB.Table("Follows").Create(&Follow{FollowerUUID: follower.UUID, FolloweeUUID: followee.UUID}).Error
+}
+
+func (a App) unfollowUser(follower User, followee User) error {
+	return a.DB.Table("Follows").Where("follower_uuid = ? AND followee_uuid = ?", follower.UUID, followee.UUID).Delete(&Follow{}).Error
+}
+
+func (a App) isFollowing(follower User, followee User) (bool, error) {
+	var count int64
+
+	err := a.DB.Table("Follows").Where("follower_uuid = ? AND followee_uuid = ?", follower.UUID, followee.UUID).Count(&count).Error
+
+	return count > 0, err
+}
+
+// Blocks or mutes target, Kind is block or mute and replaces whichever was there before
+func (a App) blockUser(user User, target User, Kind string) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Blocks").Where("user_uuid = ? AND blocked_uuid = ?", user.UUID, target.UUID).Delete(&Block{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Blocks").Create(&Block{UserUUID: user.UUID, BlockedUUID: target.UUID, Kind: Kind}).Error
+	})
+}
+
+// Lifts a block or a mute
+func (a App) unblockUser(user User, target User) error {
+	return a.DB.Table("Blocks").Where("user_uuid = ? AND blocked_uuid = ?", user.UUID, target.UUID).Delete(&Block{}).Error
+}
+
+// block, mute or "" for neither
+func (a App) getBlockKind(user User, target User) (string, error) {
+	var blocks []Block
+
+	err := a.DB.Table("Blocks").Where("user_uuid = ? AND blocked_uuid = ?", user.UUID, target.UUID).Limit(1).Find(&blocks).Error
+	if err != nil || len(blocks) == 0 {
+		return "", err
+	}
+
+	return blocks[0].Kind, nil
+}
+
+// Whether user has blocked target, mutes don't count
+func (a App) hasBlocked(user User, target User) (bool, error) {
+	kind, err := a.getBlockKind(user, target)
+
+	return kind == "block", err
+}
+
+// Everyone the user has blocked or muted, newest first
+func (a App) getBlocks(user User) ([]Block, error) {
+	var blocks []Block
+
+	err := a.DB.Table("Blocks").Where("user_uuid = ?", user.UUID).Order("created_at DESC").Find(&blocks).Error
+
+	return blocks, err
+}
+
+// UUIDs of users viewer blocked or muted, of deleted accounts and of shadowbanned ones viewer can't see past,
+// as a subquery for NOT IN
+func (a App) hiddenUsers(viewer User) *gorm.DB {
+	return a.DB.Raw("SELECT blocked_uuid FROM Blocks WHERE user_uuid = ? UNION ? UNION ?", viewer.UUID, a.deletedUsers(), a.shadowbannedUsers(viewer))
+}
+
+// Shadowbanned users other than viewer, none for a moderator, an empty viewer gets all of them
+//
+// the viewer's moderator flag is looked up so callers can pass just a UUID
+func (a App) shadowbannedUsers(viewer User) *gorm.DB {
+	moderator := a.DB.Table("Users").Select("uuid").Where("uuid = ? AND moderator = ?", viewer.UUID, true)
+
+	return a.DB.Table("Users").Select("uuid").Where("shadowbanned = ? AND uuid <> ? AND NOT EXISTS (?)", true, viewer.UUID, moderator)
+}
+
+// Whether the user's reactions and comments count on posts, a shadowbanned user's don't
+func countsOnPosts(tx *gorm.DB, UUID string) (bool, error) {
+	var count int64
+
+	err := tx.Table("Users").Where("uuid = ? AND shadowbanned = ?", UUID, true).Count(&count).Error
+
+	return count == 0, err
+}
+
+// Flips the shadowban and takes the user's reactions and comments off the post counters, or puts them back
+func (a App) setShadowbanned(user User, Shadowbanned bool) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Table("Users").Where("uuid = ? AND shadowbanned = ?", user.UUID, !Shadowbanned).UpdateColumn("shadowbanned", Shadowbanned)
+		if res.Error != nil || res.RowsAffected == 0 {
+			// already that way, the counters already match
+			return res.Error
+		}
+
+		op := " + 1"
+		if Shadowbanned {
+			op = " - 1"
+		}
+
+		var likes []Like
+		err := tx.Table("Likes").Where("user_uuid = ?", user.UUID).Find(&likes).Error
+		if err != nil {
+			return err
+		}
+		for _, like := range likes {
+			updates := map[string]interface{}{"likes": gorm.Expr("likes" + op)}
+			if column, ok := reactionColumns[like.Type]; ok {
+				updates[column] = gorm.Expr(column + op)
+			}
+			err = tx.Table("Posts").Where("uuid = ?", like.PostUUID).Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// the same comments deleteComment and publishComment count
+		var comments []Comment
+		err = tx.Table("Comments").Where("user_uuid = ? AND deleted = ? AND automod = ''", user.UUID, false).Find(&comments).Error
+		if err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			err = tx.Table("Posts").Where("uuid = ?", comment.PostUUID).UpdateColumn("comments", gorm.Expr("comments" + op)).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Whether viewer gets to see what user does, only moderators and the user see past a shadowban
+func canSee(user User, viewer ApplicationState) bool {
+	return !user.Shadowbanned || viewer.Moderator || (viewer.SignedIn && viewer.UUID == user.UUID)
+}
+
+// Whether viewer gets to see the post, withheld posts show only to moderators and the author,
+// who sees a shadow-hidden post like any other
+func (a App) canSeePost(post Post, viewer ApplicationState) bool {
+	if post.Automod != "" && !(viewer.Moderator || (viewer.SignedIn && post.UserUUID == viewer.UUID)) {
+		return false
+	}
+
+	author, err := a.getUserByUUID(post.UserUUID)
+	return err != nil || canSee(author, viewer)
+}
+
+// Returns follower count then following count, leaving out shadowbanned users viewer can't see past
+func (a App) getFollowCounts(user User, viewer User) (int64, int64, error) {
+	var followers, following int64
+
+	err := a.DB.Table("Follows").Where("followee_uuid = ? AND follower_uuid NOT IN (?)", user.UUID, a.shadowbannedUsers(viewer)).
+		Count(&followers).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = a.DB.Table("Follows").Where("follower_uuid = ? AND followee_uuid NOT IN (?)", user.UUID, a.shadowbannedUsers(viewer)).
+		Count(&following).Error
+
+	return followers, following, err
+}
+
+// Newest posts from users that user follows
+//
+// Before is the Seq of the last post on the previous page, 0 for the first page
+func (a App) getFollowingFeed(user User, Limit int, Before int64) ([]Post, error) {
+	var posts []Post
+
+	followees := a.DB.Table("Follows").Select("followee_uuid").Where("follower_uuid = ?", user.UUID)
+	query := a.DB.Table("Posts").Select("rowid AS seq, *").Where("user_uuid IN (?) AND user_uuid NOT IN (?) AND deleted = ?", followees, a.hiddenUsers(user), false).
+		Where("(automod = '' OR user_uuid = ?)", user.UUID)
+	if Before > 0 {
+		query = query.Where("rowid < ?", Before)
+	}
+
+	err := query.Order("rowid DESC").Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+// windows for the top-of-period feed, anything else means all time
+var feedPeriods = map[string]time.Duration{
+	"day": 24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// Global feed for the front page
+//
+// Sort is new, hot or top. Period narrows top to a key of feedPeriods and is ignored otherwise,
+// posts from users viewer blocked or muted are left out
+func (a App) getFeed(viewer User, Sort string, Period string, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	query := a.DB.Table("Posts").Where("user_uuid NOT IN (?) AND deleted = ?", a.hiddenUsers(viewer), false).
+		Where("(automod = '' OR user_uuid = ?)", viewer.UUID).Offset(Offset).Limit(Limit)
+
+	switch Sort {
+	case "new":
+		query = query.Order("created_at DESC, rowid DESC")
+	case "hot":
+		// comments count double, score falls off with the square of age in hours
+		query = query.Order(clause.OrderBy{Expression: gorm.Expr(
+			"(likes + 2 * comments + 1) / (((julianday('now') - julianday(created_at)) * 24 + 2) * ((julianday('now') - julianday(created_at)) * 24 + 2)) DESC",
+		)})
+	default:
+		if window, ok := feedPeriods[Period]; ok {
+			query = query.Where("julianday(created_at) >= julianday(?)", time.Now().Add(-window))
+		}
+		query = query.Order("likes DESC, created_at DESC")
+	}
+
+	err := query.Find(&posts).Error
+
+	return posts, err
+}
+
+// Stamps rows from before timestamps existed with the migration time so they sort and filter sanely
+func (a App) backfillTimestamps() error {
+	for _, table := range []string{"Users", "Posts", "Likes", "Comments"} {
+		err := a.DB.Table(table).Where("created_at IS NULL").Updates(map[string]interface{}{
+			"created_at": time.Now(),
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Columns added for soft deletes come in NULL on existing rows, which deleted = false wouldn't match
+func (a App) backfillDeleted() error {
+	for _, table := range []string{"Users", "Posts", "Comments"} {
+		err := a.DB.Table(table).Where("deleted IS NULL").Update("deleted", false).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Same for the automod column, NULL would slip past automod = ''
+func (a App) backfillAutomod() error {
+	for _, table := range []string{"Posts", "Comments"} {
+		err := a.DB.Table(table).Where("automod IS NULL").Update("automod", "").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Limit for how many top posts to get
+//
+// offset for pagination, viewer's blocked and muted users are left out
+func (a App) getTopPosts(viewer User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	err := a.DB.Table("Posts").Where("user_uuid NOT IN (?) AND deleted = ?", a.hiddenUsers(viewer), false).
+		Where("(automod = '' OR user_uuid = ?)", viewer.UUID).
+		Offset(Offset).Limit(Limit).Order("likes DESC").Find(&posts).Error
+
+	return posts, err
+}
+
+func (a App) createComment(comment Comment) (string, error) {
+	id := uuid.New()
+	comment.UUID = id.String()
+
+	err := a.DB.Table("Comments").Create(&comment).Error
+
+	if err != nil {
+		return "", err
+	}
+
+	// withheld comments are counted when a moderator publishes them, a shadowbanned author's when the ban lifts
+	counted, err := countsOnPosts(a.DB, comment.UserUUID)
+	if err != nil {
+		return "", err
+	}
+	if !counted || comment.Automod != "" {
+		return comment.UUID, nil
+	}
+
+	err = a.DB.Table("Posts").Where("UUID = ?", comment.PostUUID).UpdateColumn("Comments", gorm.Expr("Comments + ?", 1)).Error
+
+	if err != nil {
+		return "", err
+	}
+
+	return comment.UUID, nil
+}
+
+// Marks a comment as the answer to a form check, "" takes the answer back
+func (a App) setAcceptedAnswer(post Post, CommentUUID string) error {
+	return a.DB.Table("Posts").Where("uuid = ?", post.UUID).UpdateColumn("accepted_uuid", CommentUUID).Error
+}
+
+// Top level comments on a post, oldest first, without the ones from users viewer blocked or muted
+func (a App) getCommentsByPost(post Post, viewer User, Limit int, Offset int) ([]Comment, error) {
+	var comments []Comment
+
+	err := a.DB.Table("Comments").Where("post_uuid = ? AND (parent_uuid = '' OR parent_uuid IS NULL)", post.UUID).
+		Where("user_uuid NOT IN (?) AND (automod = '' OR user_uuid = ?)", a.hiddenUsers(viewer), viewer.UUID).
+		Order("created_at ASC, rowid ASC").Offset(Offset).Limit(Limit).Find(&comments).Error
+
+	return comments, err
+}
+
+// Every reply on a post, oldest first, for threadComments to hang under their parents
+//
+// replies from hidden users are dropped, and with them anything answering those replies
+func (a App) getRepliesByPost(post Post, viewer User) ([]Comment, error) {
+	var comments []Comment
+
+	err := a.DB.Table("Comments").Where("post_uuid = ? AND parent_uuid <> ''", post.UUID).
+		Where("user_uuid NOT IN (?) AND (automod = '' OR user_uuid = ?)", a.hiddenUsers(viewer), viewer.UUID).
+		Order("created_at ASC, rowid ASC").Find(&comments).Error
+
+	return comments, err
+}
+
+func (a App) getCommentByUUID(UUID string) (Comment, error) {
+	var comment Comment
+
+	err := a.DB.Table("Comments").First(&comment, "uuid = ?", UUID).Error
+
+	return comment, err
+}
+
+// A comment viewer is allowed to see, filtered the same way as getCommentsByPost
+func (a App) getVisibleComment(UUID string, viewer User) (Comment, error) {
+	var comment Comment
+
+	err := a.DB.Table("Comments").Where("user_uuid NOT IN (?) AND (automod = '' OR user_uuid = ?)", a.hiddenUsers(viewer), viewer.UUID).
+		First(&comment, "uuid = ?", UUID).Error
+
+	return comment, err
+}
+
+// Snapshots used for revision history
+func postRevision(post Post) Revision {
+	return Revision{
+		TargetUUID: post.UUID,
+		TargetType: "post",
+		Title: post.Title,
+		Description: post.Description,
+		Weight: post.Weight,
+		Reps: post.Reps,
+		Sets: post.Sets,
+		Lift: post.Lift,
+	}
+}
+
+func commentRevision(comment Comment) Revision {
+	return Revision{
+		TargetUUID: comment.UUID,
+		TargetType: "comment",
+		Content: comment.Content,
+	}
+}
+
+// Saves the post's current text as a revision and applies the edit
+func (a App) editPost(post Post, edited Post, editor User) error {
+	// votes and lights were on the old numbers, a changed lift has to be witnessed and judged again
+	changed := post.Weight != edited.Weight || post.Lift != edited.Lift || post.Reps != edited.Reps || post.Sets != edited.Sets
+	relift := post.Verification != "" && changed
+	rejudge := post.Judging != "" && changed
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		if relift {
+			err := tx.Table("VerificationVotes").Where("post_uuid = ?", post.UUID).Delete(&VerificationVote{}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Table("Posts").Where("uuid = ?", post.UUID).UpdateColumn("verification", "pending").Error
+			if err != nil {
+				return err
+			}
+		}
+		if rejudge {
+			err := tx.Table("Judgements").Where("post_uuid = ?", post.UUID).Delete(&Judgement{}).Error
+			if err != nil {
+				return err
+			}
+			// back to never submitted, the author asks for a new panel
+			err = tx.Table("Posts").Where("uuid = ?", post.UUID).UpdateColumn("judging", "").Error
+			if err != nil {
+				return err
+			}
+		}
+
+		revision := postRevision(post)
+		revision.UUID = uuid.New().String()
+		revision.EditorUUID = editor.UUID
+
+		err := tx.Table("Revisions").Create(&revision).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Posts").Where("uuid = ?", post.UUID).Updates(map[string]interface{}{
+			"title": edited.Title,
+			"description": edited.Description,
+			"weight": edited.Weight,
+			"lift": edited.Lift,
+			"reps": edited.Reps,
+			"sets": edited.Sets,
+			"form_check": edited.FormCheck,
+			"gym_uuid": edited.GymUUID,
+			"gym_name": edited.GymName,
+			"automod": edited.Automod,
+			"edited_at": time.Now(),
+			"updated_at": time.Now(),
+		}).Error
+	})
+}
+
+// Saves the comment's current text as a revision and applies the edit
+func (a App) editComment(comment Comment, Content string, Automod string, editor User) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		revision := commentRevision(comment)
+		revision.UUID = uuid.New().String()
+		revision.EditorUUID = editor.UUID
+
+		err := tx.Table("Revisions").Create(&revision).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Table("Comments").Where("uuid = ?", comment.UUID).Updates(map[string]interface{}{
+			"content": Content,
+			"automod": Automod,
+			"edited_at": time.Now(),
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil || comment.Automod != "" || Automod == "" {
+			return err
+		}
+
+		// withheld by this edit, it stops counting until a moderator publishes it
+		counted, err := countsOnPosts(tx, comment.UserUUID)
+		if err != nil || !counted {
+			return err
+		}
+		return tx.Table("Posts").Where("uuid = ?", comment.PostUUID).UpdateColumn("comments", gorm.Expr("comments - ?", 1)).Error
+	})
+}
+
+// Prior versions of a post or comment, oldest first
+func (a App) getRevisions(TargetUUID string) ([]Revision, error) {
+	var revisions []Revision
+
+	err := a.DB.Table("Revisions").Where("target_uuid = ?", TargetUUID).Order("created_at ASC").Find(&revisions).Error
+
+	return revisions, err
+}
+
+// notification types in the order the preferences form lists them
+var notificationTypes = []struct {
+	Type string
+	Label string
+}{
+	{"like", "Reactions to your posts"},
+	{"comment", "Comments on your posts"},
+	{"reply", "Replies to your comments"},
+	{"follow", "New followers"},
+	{"mention", "Mentions"},
+	{"accepted", "Answers accepted on form checks"},
+	{"challenge", "Results of challenges you entered"},
+	{"verified", "Your lifts getting verified"},
+	{"judge", "Being asked to judge a lift"},
+	{"judged", "Referee decisions on your lifts"},
+	{"report", "Outcomes of your reports"},
+}
+
+// Records an event unless it's the recipient's own doing, they've turned the type off,
+// or the same actor already has an unread one on the same target
+func (a App) notify(notification Notification) error {
+	if notification.UserUUID == "" || notification.UserUUID == notification.ActorUUID {
+		return nil
+	}
+
+	blocked, err := a.hasBlocked(User{UUID: notification.UserUUID}, User{UUID: notification.ActorUUID})
+	if err != nil || blocked {
+		return err
+	}
+
+	var count int64
+	err = a.DB.Table("NotificationPreferences").
+		Where("user_uuid = ? AND type = ? AND enabled = ?", notification.UserUUID, notification.Type, false).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+
+	err = a.DB.Table("Notifications").Where("user_uuid = ? AND type = ? AND actor_uuid = ? AND target_uuid = ? AND read = ?",
+		notification.UserUUID, notification.Type, notification.ActorUUID, notification.TargetUUID, false).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+
+	notification.UUID = uuid.New().String()
+
+	return a.DB.Table("Notifications").Create(&notification).Error
+}
+
+// Takes back an unread event, for unlikes and unfollows
+func (a App) unnotify(Type string, ActorUUID string, TargetUUID string) error {
+	return a.DB.Table("Notifications").Where("type = ? AND actor_uuid = ? AND target_uuid = ? AND read = ?",
+		Type, ActorUUID, TargetUUID, false).Delete(&Notification{}).Error
+}
+
+// Notifications collapsed by type and target, newest first, read and unread kept apart
+func (a App) getNotificationGroups(user User, Limit int, Offset int) ([]NotificationGroup, error) {
+	var groups []NotificationGroup
+
+	err := a.DB.Table("Notifications").
+		Select("type, target_uuid, post_uuid, read, COUNT(DISTINCT actor_uuid) AS actors").
+		Where("user_uuid = ? AND actor_uuid NOT IN (?)", user.UUID, a.hiddenUsers(user)).
+		Group("type, target_uuid, post_uuid, read").
+		Order("read ASC, MAX(created_at) DESC").
+		Offset(Offset).Limit(Limit).Scan(&groups).Error
+	if err != nil {
+		return groups, err
+	}
+
+	for i, group := range groups {
+		var latest Notification
+
+		err = a.DB.Table("Notifications").Where("user_uuid = ? AND type = ? AND target_uuid = ? AND read = ?",
+			user.UUID, group.Type, group.TargetUUID, group.Read).
+			Where("actor_uuid NOT IN (?)", a.hiddenUsers(user)).Order("created_at DESC").First(&latest).Error
+		if err != nil {
+			return groups, err
+		}
+
+		group.ActorUUID = latest.ActorUUID
+		group.ActorName = latest.ActorName
+		group.Latest = latest.CreatedAt
+		group.Note = latest.Note
+		groups[i] = group
+	}
+
+	return groups, nil
+}
+
+// Number of unread groups, which is what the notifications page shows as new
+func (a App) countUnreadNotifications(user User) (int64, error) {
+	var count int64
+
+	groups := a.DB.Table("Notifications").Select("type, target_uuid").
+		Where("user_uuid = ? AND read = ? AND actor_uuid NOT IN (?)", user.UUID, false, a.hiddenUsers(user)).Group("type, target_uuid")
+	err := a.DB.Table("(?) AS groups", groups).Count(&count).Error
+
+	return count, err
+}
+
+// Marks one group read, or everything when Type is empty
+func (a App) markNotificationsRead(user User, Type string, TargetUUID string) error {
+	query := a.DB.Table("Notifications").Where("user_uuid = ?", user.UUID)
+	if Type != "" {
+		query = query.Where("type = ? AND target_uuid = ?", Type, TargetUUID)
+	}
+
+	return query.Update("read", true).Error
+}
+
+// Returns every notification type mapped to whether the user gets it
+func (a App) getNotificationPreferences(user User) (map[string]bool, error) {
+	var preferences []NotificationPreference
+
+	err := a.DB.Table("NotificationPreferences").Where("user_uuid = ?", user.UUID).Find(&preferences).Error
+
+	enabled := make(map[string]bool)
+	for _, notificationType := range notificationTypes {
+		enabled[notificationType.Type] = true
+	}
+	for _, preference := range preferences {
+		enabled[preference.Type] = preference.Enabled
+	}
+
+	return enabled, err
+}
+
+func (a App) setNotificationPreference(user User, Type string, Enabled bool) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("NotificationPreferences").Where("user_uuid = ? AND type = ?", user.UUID, Type).Delete(&NotificationPreference{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("NotificationPreferences").Create(&NotificationPreference{UserUUID: user.UUID, Type: Type, Enabled: Enabled}).Error
+	})
+}
+
+// everyone else in a collapsed group, for "Alice and 11 others"
+func (g NotificationGroup) Others() int {
+	return g.Actors - 1
+}
+
+// Replaces the mentions and tags stored for a post or comment with the ones in Text
+//
+// users mentioned for the first time get a notification from actor
+func (a App) recordMarkup(SourceUUID string, SourceType string, PostUUID string, Text string, actor User) error {
+	var previous []string
+
+	err := a.DB.Table("Mentions").Where("source_uuid = ?", SourceUUID).Pluck("user_uuid", &previous).Error
+	if err != nil {
+		return err
+	}
+
+	alreadyMentioned := make(map[string]bool)
+	for _, UserUUID := range previous {
+		alreadyMentioned[UserUUID] = true
+	}
+
+	var mentioned []User
+	for _, handle := range parseMentions(Text) {
+		user, err := a.getUserByHandle(handle)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if blocked, err := a.hasBlocked(user, actor); err != nil || blocked {
+			// blocked users can't mention the people who blocked them
+			continue
+		}
+		mentioned = append(mentioned, user)
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Mentions").Where("source_uuid = ?", SourceUUID).Delete(&Mention{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Table("Tags").Where("source_uuid = ?", SourceUUID).Delete(&Tag{}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, user := range mentioned {
+			err = tx.Table("Mentions").Create(&Mention{SourceUUID: SourceUUID, SourceType: SourceType, PostUUID: PostUUID, UserUUID: user.UUID}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, name := range parseTags(Text) {
+			err = tx.Table("Tags").Create(&Tag{Name: name, SourceUUID: SourceUUID, PostUUID: PostUUID}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, user := range mentioned {
+		if alreadyMentioned[user.UUID] {
+			continue
+		}
+
+		err = a.notify(Notification{
+			UserUUID: user.UUID,
+			Type: "mention",
+			ActorUUID: actor.UUID,
+			ActorName: actor.Name,
+			TargetUUID: SourceUUID,
+			PostUUID: PostUUID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Records the comment's mentions and tags and tells the people it replies to, once it's visible
+func (a App) announceComment(comment Comment, post Post, parent Comment) {
+	author := User{UUID: comment.UserUUID, Name: comment.UserName}
+
+	err := a.recordMarkup(comment.UUID, "comment", post.UUID, comment.Content, author)
+	if err != nil {
+		fmt.Println("Failed to record mentions and tags")
+	}
+
+	if parent.UUID != "" {
+		err = a.notify(Notification{
+			UserUUID: parent.UserUUID,
+			Type: "reply",
+			ActorUUID: comment.UserUUID,
+			ActorName: comment.UserName,
+			TargetUUID: parent.UUID,
+			PostUUID: post.UUID,
+		})
+		if err != nil {
+			fmt.Println("Failed to send reply notification")
+		}
+	}
+	if parent.UserUUID != post.UserUUID {
+		// a reply to the author's own comment already told them
+		err = a.notify(Notification{
+			UserUUID: post.UserUUID,
+			Type: "comment",
+			ActorUUID: comment.UserUUID,
+			ActorName: comment.UserName,
+			TargetUUID: post.UUID,
+			PostUUID: post.UUID,
+		})
+		if err != nil {
+			fmt.Println("Failed to send comment notification")
+		}
+	}
+}
+
+// Newest posts with a tag in their description or comments
+func (a App) getPostsByTag(Name string, viewer User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	tagged := a.DB.Table("Tags").Select("post_uuid").Where("name = ?", strings.ToLower(Name)).
+		Where("source_uuid NOT IN (?)", a.DB.Table("Comments").Select("uuid").Where("deleted = ?", true))
+	err := a.DB.Table("Posts").Where("uuid IN (?) AND user_uuid NOT IN (?) AND deleted = ?", tagged, a.hiddenUsers(viewer), false).
+		Where("(automod = '' OR user_uuid = ?)", viewer.UUID).
+		Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+// Returns the gym UUID, whoever founds it is its first admin
+func (a App) createGym(gym Gym, founder User) (string, error) {
+	gym.UUID = uuid.New().String()
+	gym.Members = 1
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Gyms").Create(&gym).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("GymMembers").Create(&GymMember{
+			GymUUID: gym.UUID,
+			UserUUID: founder.UUID,
+			UserName: founder.Name,
+			Admin: true,
+			Approved: true,
+		}).Error
+	})
+
+	return gym.UUID, err
+}
+
+func (a App) getGymByUUID(UUID string) (Gym, error) {
+	var gym Gym
+
+	err := a.DB.Table("Gyms").Where("uuid = ?", UUID).First(&gym).Error
+
+	return gym, err
+}
+
+// Biggest gyms first
+func (a App) getGyms(Limit int, Offset int) ([]Gym, error) {
+	var gyms []Gym
+
+	err := a.DB.Table("Gyms").Order("members DESC, name ASC").Offset(Offset).Limit(Limit).Find(&gyms).Error
+
+	return gyms, err
+}
+
+// Gyms the user is an approved member of, for tagging posts
+func (a App) getGymsByUser(user User) ([]Gym, error) {
+	var gyms []Gym
+
+	memberships := a.DB.Table("GymMembers").Select("gym_uuid").Where("user_uuid = ? AND approved = ?", user.UUID, true)
+	err := a.DB.Table("Gyms").Where("uuid IN (?)", memberships).Order("name ASC").Find(&gyms).Error
+
+	return gyms, err
+}
+
+// Updates the gym's details and the name copied onto its posts
+func (a App) editGym(gym Gym, edited Gym) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Gyms").Where("uuid = ?", gym.UUID).Updates(map[string]interface{}{
+			"name": edited.Name,
+			"location": edited.Location,
+			"description": edited.Description,
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Posts").Where("gym_uuid = ?", gym.UUID).UpdateColumn("gym_name", edited.Name).Error
+	})
+}
+
+// Removes the gym and its memberships, posts stay up without the gym tag
+func (a App) deleteGym(gym Gym) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Posts").Where("gym_uuid = ?", gym.UUID).Updates(map[string]interface{}{
+			"gym_uuid": "",
+			"gym_name": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Table("GymMembers").Where("gym_uuid = ?", gym.UUID).Delete(&GymMember{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Gyms").Where("uuid = ?", gym.UUID).Delete(&Gym{}).Error
+	})
+}
+
+// Errors with gorm.ErrRecordNotFound if the user never asked to join
+func (a App) getGymMember(gym Gym, user User) (GymMember, error) {
+	var member GymMember
+
+	err := a.DB.Table("GymMembers").Where("gym_uuid = ? AND user_uuid = ?", gym.UUID, user.UUID).First(&member).Error
+
+	return member, err
+}
+
+// Approved members when approved is true, otherwise the requests waiting on an admin
+func (a App) getGymMembers(gym Gym, approved bool) ([]GymMember, error) {
+	var members []GymMember
+
+	err := a.DB.Table("GymMembers").Where("gym_uuid = ? AND approved = ?", gym.UUID, approved).
+		Order("admin DESC, user_name ASC").Find(&members).Error
+
+	return members, err
+}
+
+func (a App) isGymAdmin(gym Gym, user User) bool {
+	member, err := a.getGymMember(gym, user)
+
+	return err == nil && member.Approved && member.Admin
+}
+
+// Asks to join, asking twice is a no-op
+func (a App) joinGym(gym Gym, user User) error {
+	_, err := a.getGymMember(gym, user)
+	if err == nil {
+		return nil
+	}
+
+	return a.DB.Table("GymMembers").Create(&GymMember{
+		GymUUID: gym.UUID,
+		UserUUID: user.UUID,
+		UserName: user.Name,
+	}).Error
+}
+
+func (a App) approveGymMember(gym Gym, member GymMember) error {
+	if member.Approved {
+		return nil
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("GymMembers").Where("gym_uuid = ? AND user_uuid = ?", gym.UUID, member.UserUUID).
+			UpdateColumn("approved", true).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Gyms").Where("uuid = ?", gym.UUID).UpdateColumn("members", gorm.Expr("members + ?", 1)).Error
+	})
+}
+
+// Turns down a request or removes a member, their posts come out of the gym's feed
+func (a App) removeGymMember(gym Gym, member GymMember) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("GymMembers").Where("gym_uuid = ? AND user_uuid = ?", gym.UUID, member.UserUUID).Delete(&GymMember{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Table("Posts").Where("gym_uuid = ? AND user_uuid = ?", gym.UUID, member.UserUUID).Updates(map[string]interface{}{
+			"gym_uuid": "",
+			"gym_name": "",
+		}).Error
+		if err != nil || !member.Approved {
+			return err
+		}
+
+		return tx.Table("Gyms").Where("uuid = ?", gym.UUID).UpdateColumn("members", gorm.Expr("members - ?", 1)).Error
+	})
+}
+
+func (a App) setGymAdmin(gym Gym, member GymMember, Admin bool) error {
+	return a.DB.Table("GymMembers").Where("gym_uuid = ? AND user_uuid = ? AND approved = ?", gym.UUID, member.UserUUID, true).
+		UpdateColumn("admin", Admin).Error
+}
+
+func (a App) countGymAdmins(gym Gym) (int64, error) {
+	var count int64
+
+	err := a.DB.Table("GymMembers").Where("gym_uuid = ? AND admin = ?", gym.UUID, true).Count(&count).Error
+
+	return count, err
+}
+
+// Posts tagged with the gym, newest first
+func (a App) getPostsByGym(gym Gym, viewer User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	err := a.DB.Table("Posts").Where("gym_uuid = ? AND user_uuid NOT IN (?) AND deleted = ?", gym.UUID, a.hiddenUsers(viewer), false).
+		Where("(automod = '' OR user_uuid = ?)", viewer.UUID).Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+// Gym admins take a post out of their feed, the post itself stays up
+func (a App) removePostFromGym(post Post) error {
+	return a.DB.Table("Posts").Where("uuid = ?", post.UUID).Updates(map[string]interface{}{
+		"gym_uuid": "",
+		"gym_name": "",
+	}).Error
+}
+
+// Lifts posted at the gym, most posted first, lowercased so "Squat" and "squat" are one board
+func (a App) getGymLifts(gym Gym, viewer User) ([]string, error) {
+	var lifts []string
+
+	err := a.DB.Table("Posts").Where("gym_uuid = ? AND trim(lift) != '' AND deleted = ? AND automod = ''", gym.UUID, false).
+		Where("user_uuid NOT IN (?) AND user_uuid NOT IN (?)", a.deletedUsers(), a.shadowbannedUsers(viewer)).
+		Group("lower(trim(lift))").Order("COUNT(*) DESC").Pluck("lower(trim(lift))", &lifts).Error
+
+	return lifts, err
+}
+
+// Each member's heaviest post of a lift at the gym, heaviest first
+//
+// verified leaves out every lift that hasn't passed verification
+func (a App) getGymLeaderboard(gym Gym, Lift string, verified bool, viewer User, Limit int) ([]LeaderboardEntry, error) {
+	var entries []LeaderboardEntry
+
+	query := a.DB.Table("Posts").Where("gym_uuid = ? AND lower(trim(lift)) = ?", gym.UUID, strings.ToLower(strings.TrimSpace(Lift))).
+		Where("deleted = ? AND automod = '' AND user_uuid NOT IN (?)", false, a.deletedUsers()).
+		Where("user_uuid NOT IN (?)", a.shadowbannedUsers(viewer))
+	if verified {
+		query = query.Where("verification = ?", "verified")
+	}
+
+	// sqlite fills the bare columns from the row that had the MAX
+	err := query.Select("user_uuid, user_name, uuid AS post_uuid, MAX(weight) AS weight").
+		Group("user_uuid").Order("weight DESC").Limit(Limit).Scan(&entries).Error
+
+	return entries, err
+}
+
+// Opens a post to verification votes, asking again keeps the votes already in
+func (a App) requestVerification(post Post) error {
+	if post.Verification != "" {
+		return nil
+	}
+
+	return a.setVerification(post, "pending")
+}
+
+func (a App) setVerification(post Post, Status string) error {
+	return a.DB.Table("Posts").Where("uuid = ?", post.UUID).UpdateColumn("verification", Status).Error
+}
+
+// Records or replaces the user's vote on a post
+func (a App) voteVerification(post Post, user User, Legit bool, Standard bool) error {
+	vote := VerificationVote{
+		PostUUID: post.UUID,
+		UserUUID: user.UUID,
+		UserName: user.Name,
+		Legit: Legit,
+		Standard: Standard,
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("VerificationVotes").Where("post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Delete(&VerificationVote{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("VerificationVotes").Create(&vote).Error
+	})
+}
+
+// Errors with gorm.ErrRecordNotFound if the user hasn't voted
+func (a App) getVerificationVote(post Post, user User) (VerificationVote, error) {
+	var vote VerificationVote
+
+	err := a.DB.Table("VerificationVotes").Where("post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).First(&vote).Error
+
+	return vote, err
+}
+
+// Every vote on a post, oldest first
+func (a App) getVerificationVotes(post Post) ([]VerificationVote, error) {
+	var votes []VerificationVote
+
+	err := a.DB.Table("VerificationVotes").Where("post_uuid = ?", post.UUID).Order("created_at ASC").Find(&votes).Error
+
+	return votes, err
+}
+
+// Returns votes for the lift then votes against it
+func (a App) countVerificationVotes(post Post) (int64, int64, error) {
+	var approvals, objections int64
+
+	err := a.DB.Table("VerificationVotes").Where("post_uuid = ? AND legit = ? AND standard = ?", post.UUID, true, true).Count(&approvals).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	err = a.DB.Table("VerificationVotes").Where("post_uuid = ? AND (legit = ? OR standard = ?)", post.UUID, false, false).Count(&objections).Error
+
+	return approvals, objections, err
+}
+
+func (a App) setVerifier(user User, Verifier bool) error {
+	return a.DB.Table("Users").Where("uuid = ?", user.UUID).UpdateColumn("verifier", Verifier).Error
+}
+
+// Opens a lift to a referee panel
+func (a App) requestJudging(post Post) error {
+	return a.DB.Table("Posts").Where("uuid = ? AND (judging = '' OR judging IS NULL)", post.UUID).UpdateColumn("judging", "open").Error
+}
+
+// Seats on the panel, left to right
+func (a App) getJudgements(post Post) ([]Judgement, error) {
+	var judgements []Judgement
+
+	err := a.DB.Table("Judgements").Where("post_uuid = ?", post.UUID).Order("seat ASC").Find(&judgements).Error
+
+	return judgements, err
+}
+
+// Puts the user in the first free seat, sitting twice is a no-op
+func (a App) takeJudgingSeat(post Post, user User) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		var judgements []Judgement
+		err := tx.Table("Judgements").Where("post_uuid = ?", post.UUID).Find(&judgements).Error
+		if err != nil {
+			return err
+		}
+
+		taken := make(map[int]bool)
+		for _, judgement := range judgements {
+			if judgement.JudgeUUID == user.UUID {
+				return nil
+			}
+			taken[judgement.Seat] = true
+		}
+
+		for seat := 1; seat <= judgingSeats; seat++ {
+			if !taken[seat] {
+				return tx.Table("Judgements").Create(&Judgement{
+					PostUUID: post.UUID,
+					JudgeUUID: user.UUID,
+					JudgeName: user.Name,
+					Seat: seat,
+				}).Error
+			}
+		}
+
+		return errSeatsFull
+	})
+}
+
+// Gives up a seat, only before the judge has put up a light
+func (a App) leaveJudgingSeat(post Post, user User) error {
+	return a.DB.Table("Judgements").Where("post_uuid = ? AND judge_uuid = ? AND light = ''", post.UUID, user.UUID).Delete(&Judgement{}).Error
+}
+
+// Records or changes the judge's light while the panel is still open
+func (a App) submitLight(post Post, user User, Light string, Faults string) error {
+	return a.DB.Table("Judgements").Where("post_uuid = ? AND judge_uuid = ?", post.UUID, user.UUID).Updates(map[string]interface{}{
+		"light": Light,
+		"faults": Faults,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// Closes the panel with good or nolift, only the first caller gets true
+func (a App) publishJudging(post Post, Result string) (bool, error) {
+	result := a.DB.Table("Posts").Where("uuid = ? AND judging = ?", post.UUID, "open").UpdateColumn("judging", Result)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// Lifts the user has judged to a result and how many their light agreed with
+func (a App) getJudgeStats(user User) (JudgeStats, error) {
+	var stats JudgeStats
+
+	err := a.DB.Table("Judgements").Joins("JOIN Posts ON Posts.uuid = Judgements.post_uuid").
+		Select("COUNT(*) AS judged, COALESCE(SUM((Judgements.light = 'white') = (Posts.judging = 'good')), 0) AS agreed").
+		Where("Judgements.judge_uuid = ? AND Posts.judging IN ?", user.UUID, []string{"good", "nolift"}).
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// Saves a post, into a collection if one is given, saving twice is a no-op
+func (a App) bookmarkPost(post Post, user User, CollectionUUID string) error {
+	bookmarked, err := a.isBookmarked(post, user)
+	if err != nil || bookmarked {
+		return err
+	}
+
+	return a.DB.Table("Bookmarks").Create(&Bookmark{UserUUID: user.UUID, PostUUID: post.UUID, CollectionUUID: CollectionUUID}).Error
+}
+
+func (a App) removeBookmark(post Post, user User) error {
+	return a.DB.Table("Bookmarks").Where("user_uuid = ? AND post_uuid = ?", user.UUID, post.UUID).Delete(&Bookmark{}).Error
+}
+
+func (a App) isBookmarked(post Post, user User) (bool, error) {
+	var count int64
+
+	err := a.DB.Table("Bookmarks").Where("user_uuid = ? AND post_uuid = ?", user.UUID, post.UUID).Count(&count).Error
+
+	return count > 0, err
+}
+
+// Files a bookmark under a collection, "" takes it back out
+func (a App) moveBookmark(post Post, user User, CollectionUUID string) error {
+	return a.DB.Table("Bookmarks").Where("user_uuid = ? AND post_uuid = ?", user.UUID, post.UUID).
+		UpdateColumn("collection_uuid", CollectionUUID).Error
+}
+
+// Bookmarked posts in a collection, "" for the unfiled ones, most recently saved first
+//
+// viewer is who's looking, public collections are seen by others
+func (a App) getBookmarkedPosts(user User, CollectionUUID string, viewer User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	err := a.DB.Table("Posts").Select("Posts.*").Joins("JOIN Bookmarks ON Bookmarks.post_uuid = Posts.uuid").
+		Where("Bookmarks.user_uuid = ? AND Bookmarks.collection_uuid = ?", user.UUID, CollectionUUID).
+		Where("Posts.deleted = ? AND Posts.automod = '' AND Posts.user_uuid NOT IN (?)", false, a.deletedUsers()).
+		Where("Posts.user_uuid NOT IN (?)", a.shadowbannedUsers(viewer)).
+		Order("Bookmarks.created_at DESC").Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+// Returns the collection UUID
+func (a App) createCollection(collection Collection) (string, error) {
+	collection.UUID = uuid.New().String()
+
+	err := a.DB.Table("Collections").Create(&collection).Error
+
+	return collection.UUID, err
+}
+
+func (a App) getCollectionByUUID(UUID string) (Collection, error) {
+	var collection Collection
+
+	err := a.DB.Table("Collections").Where("uuid = ?", UUID).First(&collection).Error
+
+	return collection, err
+}
+
+// The user's collections by name, private ones only when includePrivate is set
+func (a App) getCollectionsByUser(user User, includePrivate bool) ([]Collection, error) {
+	var collections []Collection
+
+	query := a.DB.Table("Collections").Where("user_uuid = ?", user.UUID)
+	if !includePrivate {
+		query = query.Where("public = ?", true)
+	}
+	err := query.Order("name ASC").Find(&collections).Error
+
+	return collections, err
+}
+
+func (a App) editCollection(collection Collection, Name string, Public bool) error {
+	return a.DB.Table("Collections").Where("uuid = ?", collection.UUID).Updates(map[string]interface{}{
+		"name": Name,
+		"public": Public,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// Removes the collection, its bookmarks go back to unfiled rather than being lost
+func (a App) deleteCollection(collection Collection) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Bookmarks").Where("collection_uuid = ?", collection.UUID).UpdateColumn("collection_uuid", "").Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Collections").Where("uuid = ?", collection.UUID).Delete(&Collection{}).Error
+	})
+}
+
+// Returns the challenge UUID
+func (a App) createChallenge(challenge Challenge) (string, error) {
+	challenge.UUID = uuid.New().String()
+
+	err := a.DB.Table("Challenges").Create(&challenge).Error
+
+	return challenge.UUID, err
+}
+
+func (a App) getChallengeByUUID(UUID string) (Challenge, error) {
+	var challenge Challenge
+
+	err := a.DB.Table("Challenges").Where("uuid = ?", UUID).First(&challenge).Error
+
+	return challenge, err
+}
+
+// Any challenge already using the tag, closed ones included so old badges keep meaning one thing
+func (a App) challengeTagTaken(Tag string) (bool, error) {
+	var count int64
+
+	err := a.DB.Table("Challenges").Where("tag = ?", strings.ToLower(Tag)).Count(&count).Error
+
+	return count > 0, err
+}
+
+// Running and upcoming challenges, soonest to end first
+func (a App) getOpenChallenges() ([]Challenge, error) {
+	var challenges []Challenge
+
+	err := a.DB.Table("Challenges").Where("closed = ?", false).Order("ends_at ASC").Find(&challenges).Error
+
+	return challenges, err
+}
+
+// Closed challenges, most recently ended first
+func (a App) getClosedChallenges(Limit int, Offset int) ([]Challenge, error) {
+	var challenges []Challenge
+
+	err := a.DB.Table("Challenges").Where("closed = ?", true).Order("ends_at DESC").Offset(Offset).Limit(Limit).Find(&challenges).Error
+
+	return challenges, err
+}
+
+// Challenges past their end that haven't been closed yet
+func (a App) getEndedChallenges(now time.Time) ([]Challenge, error) {
+	var challenges []Challenge
+
+	err := a.DB.Table("Challenges").Where("closed = ? AND julianday(ends_at) <= julianday(?)", false, now).Find(&challenges).Error
+
+	return challenges, err
+}
+
+// Posts that count towards the challenge, the author tagged it in their own description inside the window
+//
+// shadowbanned entries only count for the shadowbanned viewer, so they never place for anyone else
+func (a App) challengeEntries(challenge Challenge, viewer User) *gorm.DB {
+	tagged := a.DB.Table("Tags").Select("post_uuid").Where("name = ? AND source_uuid = post_uuid", challenge.Tag)
+
+	query := a.DB.Table("Posts").Where("uuid IN (?) AND julianday(created_at) >= julianday(?) AND julianday(created_at) < julianday(?) AND weight >= ?",
+		tagged, challenge.StartsAt, challenge.EndsAt, challenge.MinWeight).
+		Where("deleted = ? AND automod = '' AND user_uuid NOT IN (?)", false, a.deletedUsers()).
+		Where("user_uuid NOT IN (?)", a.shadowbannedUsers(viewer))
+
+	if challenge.Lift != "" {
+		query = query.Where("lower(trim(lift)) = ?", challenge.Lift)
+	}
+	if challenge.GymUUID != "" {
+		query = query.Where("gym_uuid = ?", challenge.GymUUID)
+	}
+	if challenge.VerifiedOnly {
+		query = query.Where("verification = ?", "verified")
+	}
+
+	return query
+}
+
+// Best scores first, ties go to whoever entered first, -1 for everyone
+func (a App) getStandings(challenge Challenge, viewer User, Limit int) ([]Standing, error) {
+	var standings []Standing
+
+	expr, ok := scoringExpr(challenge.Scoring)
+	if !ok {
+		return standings, fmt.Errorf("unknown scoring %q", challenge.Scoring)
+	}
+
+	err := a.challengeEntries(challenge, viewer).
+		Select("user_uuid, MAX(user_name) AS user_name, " + expr + " AS score, COUNT(*) AS entries, MIN(created_at) AS first_entry").
+		Group("user_uuid").Order("score DESC, first_entry ASC").Limit(Limit).Scan(&standings).Error
+
+	for i := range standings {
+		standings[i].Place = i + 1
+	}
+
+	return standings, err
+}
+
+// Entries newest first
+func (a App) getChallengePosts(challenge Challenge, viewer User, Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	err := a.challengeEntries(challenge, viewer).Where("user_uuid NOT IN (?)", a.hiddenUsers(viewer)).Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+// Records the winner and gives the top three their badges
+func (a App) closeChallenge(challenge Challenge, standings []Standing) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		closed := map[string]interface{}{"closed": true}
+		if len(standings) > 0 {
+			closed["winner_uuid"] = standings[0].UserUUID
+			closed["winner_name"] = standings[0].UserName
+		}
+
+		// only the first closer to get here hands out badges
+		result := tx.Table("Challenges").Where("uuid = ? AND closed = ?", challenge.UUID, false).Updates(closed)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		for i, standing := range standings {
+			if i == 3 {
+				break
+			}
+			err := tx.Table("Badges").Create(&Badge{
+				UserUUID: standing.UserUUID,
+				ChallengeUUID: challenge.UUID,
+				Title: challenge.Title,
+				Place: standing.Place,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Removes the challenge and any badges it gave out
+func (a App) deleteChallenge(challenge Challenge) error {
+	err := a.DB.Table("Badges").Where("challenge_uuid = ?", challenge.UUID).Delete(&Badge{}).Error
+	if err != nil {
+		return err
+	}
+	err = a.DB.Table("Notifications").Where("type = ? AND target_uuid = ?", "challenge", challenge.UUID).Delete(&Notification{}).Error
+	if err != nil {
+		return err
+	}
+
+	return a.DB.Table("Challenges").Where("uuid = ?", challenge.UUID).Delete(&Challenge{}).Error
+}
+
+// Badges newest first
+func (a App) getBadgesByUser(user User) ([]Badge, error) {
+	var badges []Badge
+
+	err := a.DB.Table("Badges").Where("user_uuid = ?", user.UUID).Order("created_at DESC").Find(&badges).Error
+
+	return badges, err
+}
+
+// biggest group conversation, including whoever started it
+const maxConversationMembers = 8
+
+// Starts a conversation, members should include the user starting it
+func (a App) createConversation(Title string, members []User) (string, error) {
+	conversation := Conversation{
+		UUID: uuid.New().String(),
+		Title: Title,
+	}
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Conversations").Create(&conversation).Error
+		if err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			err = tx.Table("ConversationMembers").Create(&ConversationMember{
+				ConversationUUID: conversation.UUID,
+				UserUUID: member.UUID,
+				UserName: member.Name,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return conversation.UUID, err
+}
+
+// The existing one to one conversation between two users, so starting a DM twice reuses it
+func (a App) findDirectConversation(first User, second User) (Conversation, bool, error) {
+	var conversation Conversation
+
+	pairs := a.DB.Table("ConversationMembers").Select("conversation_uuid").
+		Where("user_uuid IN ?", []string{first.UUID, second.UUID}).
+		Group("conversation_uuid").Having("COUNT(*) = 2")
+	sizes := a.DB.Table("ConversationMembers").Select("conversation_uuid").
+		Group("conversation_uuid").Having("COUNT(*) = 2")
+
+	err := a.DB.Table("Conversations").Where("uuid IN (?) AND uuid IN (?) AND (title = '' OR title IS NULL)", pairs, sizes).First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return conversation, false, nil
+	}
+
+	return conversation, err == nil, err
+}
+
+func (a App) getConversation(UUID string) (Conversation, error) {
+	var conversation Conversation
+
+	err := a.DB.Table("Conversations").First(&conversation, "uuid = ?", UUID).Error
+
+	return conversation, err
+}
+
+func (a App) getConversationMembers(conversation Conversation) ([]ConversationMember, error) {
+	var members []ConversationMember
+
+	err := a.DB.Table("ConversationMembers").Where("conversation_uuid = ?", conversation.UUID).Find(&members).Error
+
+	return members, err
+}
+
+// Returns gorm.ErrRecordNotFound if the user isn't in the conversation
+func (a App) getConversationMember(conversation Conversation, user User) (ConversationMember, error) {
+	var member ConversationMember
+
+	err := a.DB.Table("ConversationMembers").First(&member, "conversation_uuid = ? AND user_uuid = ?", conversation.UUID, user.UUID).Error
+
+	return member, err
+}
+
+// The user's conversations, most recently active first
+func (a App) getConversationsByUser(user User, Limit int, Offset int) ([]ConversationSummary, error) {
+	var conversations []Conversation
+
+	joined := a.DB.Table("ConversationMembers").Select("conversation_uuid").Where("user_uuid = ?", user.UUID)
+	// a conversation holding only a shadowbanned member's messages never reached the user
+	visible := a.DB.Table("Messages").Select("conversation_uuid").Where("user_uuid NOT IN (?)", a.shadowbannedUsers(user))
+	anything := a.DB.Table("Messages").Select("conversation_uuid")
+	err := a.DB.Table("Conversations").Where("uuid IN (?)", joined).Where("uuid IN (?) OR uuid NOT IN (?)", visible, anything).
+		Order("updated_at DESC").Offset(Offset).Limit(Limit).Find(&conversations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]ConversationSummary, 0, len(conversations))
+	for _, conversation := range conversations {
+		members, err := a.getConversationMembers(conversation)
+		if err != nil {
+			return summaries, err
+		}
+
+		summary := ConversationSummary{Conversation: conversation}
+		for _, member := range members {
+			if member.UserUUID == user.UUID {
+				summary.Muted = member.Muted
+				err = a.DB.Table("Messages").Where("conversation_uuid = ? AND user_uuid <> ? AND rowid > ?",
+					conversation.UUID, user.UUID, member.LastReadSeq).
+					Where("user_uuid NOT IN (?)", a.shadowbannedUsers(user)).Count(&summary.Unread).Error
+				if err != nil {
+					return summaries, err
+				}
+			} else {
+				summary.Members = append(summary.Members, member)
+			}
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
+
+func (a App) sendMessage(message Message) (string, error) {
+	message.UUID = uuid.New().String()
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Messages").Create(&message).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Table("Conversations").Where("uuid = ?", message.ConversationUUID).Update("updated_at", time.Now()).Error
+	})
 
-	return users, err
+	return message.UUID, err
 }
 
-//Delete the user
-func (a App) deleteUser(user User) error {
-	err := a.DB.Table("Users").Where("uuid = ?", user.UUID).Delete(&User{}).Error
-	if err != nil {
-		return err
-	}
-	err = a.DB.Table("Auth").Where("user_uuid = ?", user.UUID).Delete(&Auth{}).Error
-	if err != nil {
-		return err
+// Newest messages in a conversation that viewer can see, Before is the Seq of the oldest message already shown
+//
+// returned oldest first so the page reads top to bottom
+func (a App) getMessages(conversation Conversation, viewer User, Limit int, Before int64) ([]Message, error) {
+	var messages []Message
+
+	query := a.DB.Table("Messages").Select("rowid AS seq, *").Where("conversation_uuid = ? AND user_uuid NOT IN (?)", conversation.UUID, a.shadowbannedUsers(viewer))
+	if Before > 0 {
+		query = query.Where("rowid < ?", Before)
 	}
-	err = a.DB.Table("Posts").Where("user_uuid = ?", user.UUID).Delete(&Post{}).Error
-	if err != nil {
-		return err
+
+	err := query.Order("rowid DESC").Limit(Limit).Find(&messages).Error
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
 	}
 
-	return nil
+	return messages, err
 }
 
-// Returns the post UUID
-func (a App) createPost(post Post) (string, error) {
-	id := uuid.New()
-	post.UUID = id.String()
+func (a App) getMessageByUUID(UUID string) (Message, error) {
+	var message Message
 
-	err := a.DB.Table("Posts").Create(post).Error
+	err := a.DB.Table("Messages").Select("rowid AS seq, *").First(&message, "uuid = ?", UUID).Error
 
-	return post.UUID, err
+	return message, err
 }
 
-//Delete the post
-func (a App) deletePost(post Post) error {
-	err := a.DB.Table("Posts").Where("uuid = ?", post.UUID).Delete(&Post{}).Error
+// Marks everything up to the newest message in the conversation as seen by the user
+func (a App) markConversationRead(conversation Conversation, user User) error {
+	var latest int64
+
+	err := a.DB.Table("Messages").Select("COALESCE(MAX(rowid), 0)").Where("conversation_uuid = ?", conversation.UUID).Scan(&latest).Error
 	if err != nil {
 		return err
 	}
-	err = a.DB.Table("Likes").Where("post_uuid = ?", post.UUID).Delete(&Like{}).Error
+
+	return a.DB.Table("ConversationMembers").Where("conversation_uuid = ? AND user_uuid = ?", conversation.UUID, user.UUID).
+		Update("last_read_seq", latest).Error
+}
+
+func (a App) setConversationMuted(conversation Conversation, user User, Muted bool) error {
+	return a.DB.Table("ConversationMembers").Where("conversation_uuid = ? AND user_uuid = ?", conversation.UUID, user.UUID).
+		Update("muted", Muted).Error
+}
+
+// Unread messages across every conversation the user hasn't muted
+func (a App) countUnreadMessages(user User) (int64, error) {
+	var count int64
+
+	err := a.DB.Table("Messages").
+		Joins("JOIN ConversationMembers ON ConversationMembers.conversation_uuid = Messages.conversation_uuid").
+		Where("ConversationMembers.user_uuid = ? AND ConversationMembers.muted = ?", user.UUID, false).
+		Where("Messages.user_uuid <> ? AND Messages.rowid > ConversationMembers.last_read_seq", user.UUID).
+		Where("Messages.user_uuid NOT IN (?)", a.shadowbannedUsers(user)).
+		Count(&count).Error
+
+	return count, err
+}
+
+func (a App) deleteMessage(message Message) error {
+	err := a.DB.Table("Messages").Where("uuid = ?", message.UUID).Delete(&Message{}).Error
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return a.DB.Table("MessageReports").Where("message_uuid = ?", message.UUID).Update("resolved", true).Error
 }
 
-func (a App) deleteComment(comment Comment) error {
-	err := a.DB.Table("Comments").Where("uuid = ?", comment.UUID).Delete(&Comment{}).Error
+func (a App) reportMessage(report MessageReport) (string, error) {
+	report.UUID = uuid.New().String()
 
-	if err != nil {
-		return err
+	err := a.DB.Table("MessageReports").Create(&report).Error
+
+	return report.UUID, err
+}
+
+// Unresolved reports, oldest first
+func (a App) getMessageReports(Limit int, Offset int) ([]MessageReport, error) {
+	var reports []MessageReport
+
+	err := a.DB.Table("MessageReports").Where("resolved = ?", false).Order("created_at ASC").Offset(Offset).Limit(Limit).Find(&reports).Error
+
+	return reports, err
+}
+
+func (a App) resolveMessageReport(UUID string) error {
+	return a.DB.Table("MessageReports").Where("uuid = ?", UUID).Update("resolved", true).Error
+}
+
+// Files a report, or updates the reporter's open one on the same target so each person counts once
+func (a App) report(report Report) (string, error) {
+	var existing Report
+
+	err := a.DB.Table("Reports").Where("target_uuid = ? AND reporter_uuid = ? AND resolved = ?",
+		report.TargetUUID, report.ReporterUUID, false).First(&existing).Error
+	if err == nil {
+		err = a.DB.Table("Reports").Where("uuid = ?", existing.UUID).Updates(map[string]interface{}{
+			"category": report.Category,
+			"details": report.Details,
+		}).Error
+
+		return existing.UUID, err
 	}
 
-	err = a.DB.Table("Posts").Where("uuid = ?", comment.PostUUID).UpdateColumn("Comments", gorm.Expr("Comments - ?", 1)).Error
+	report.UUID = uuid.New().String()
 
-	return err
+	err = a.DB.Table("Reports").Create(&report).Error
+
+	return report.UUID, err
 }
 
-// Returns a Post object
-func (a App) getPostByUUID(UUID string) (Post, error) {
-	var post Post
+// Unresolved reports collapsed by target, most reported first and then longest waiting
+func (a App) getReportGroups(Limit int, Offset int) ([]ReportGroup, error) {
+	var groups []ReportGroup
 
-	err := a.DB.Table("Posts").First(&post, "UUID = ?", UUID).Error
+	err := a.DB.Table("Reports").
+		Select("target_type, target_uuid, COUNT(DISTINCT reporter_uuid) AS reporters").
+		Where("resolved = ?", false).
+		Group("target_type, target_uuid").
+		Order("reporters DESC, MIN(created_at) ASC").
+		Offset(Offset).Limit(Limit).Scan(&groups).Error
 
-	return post, err
+	return groups, err
 }
 
-//gets the most recent posts from a user
-func (a App) getPostsByUser(user User, Limit int, Offset int) ([]Post, error) {
-	var posts []Post
+func (a App) getReportsByTarget(TargetUUID string) ([]Report, error) {
+	var reports []Report
 
-	err := a.DB.Table("Posts").Where("user_uuid = ?", user.UUID).Offset(Offset).Limit(Limit).Find(&posts).Error
+	err := a.DB.Table("Reports").Where("target_uuid = ? AND resolved = ?", TargetUUID, false).Order("created_at ASC").Find(&reports).Error
 
-	return posts, err
+	return reports, err
 }
 
-func (a App) getAllComments(Limit int, Offset int) ([]Comment, error) {
-	var comments []Comment
+func (a App) countOpenReports() (int64, error) {
+	var count int64
 
-	err := a.DB.Table("Comments").Offset(Offset).Limit(Limit).Find(&comments).Error
+	err := a.DB.Table("Reports").Where("resolved = ?", false).Distinct("target_uuid").Count(&count).Error
 
-	return comments, err
+	return count, err
 }
 
-//Like a post
-func (a App) likePost(post Post, user User) error {
-	var has_liked Like
-	err := a.DB.Table("Likes").First(&has_liked, "post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		err := a.DB.Table("Likes").Create(&Like{UserUUID: user.UUID, PostUUID: post.UUID}).Error
-		if err != nil {
-			return err
-		}
+// Closes every open report on the target, returning who filed them so they can be told
+func (a App) resolveReports(TargetUUID string, Resolution string, moderator User) ([]string, error) {
+	var reporters []string
 
-		err = a.DB.Table("Posts").Where("UUID = ?", post.UUID).Update("likes", post.Likes + 1).Error
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Reports").Where("target_uuid = ? AND resolved = ?", TargetUUID, false).
+			Distinct().Pluck("reporter_uuid", &reporters).Error
 		if err != nil {
 			return err
 		}
-	} else if err != nil {
-		return err
-	}
 
-	return nil
+		return tx.Table("Reports").Where("target_uuid = ? AND resolved = ?", TargetUUID, false).Updates(map[string]interface{}{
+			"resolved": true,
+			"resolution": Resolution,
+			"moderator_uuid": moderator.UUID,
+			"resolved_at": time.Now(),
+		}).Error
+	})
+
+	return reporters, err
 }
 
-//remove a like
-func (a App) removeLike(post Post, user User) error {
-	var has_liked Like
+// Bans also take the account's email and end every session in the same transaction
+func (a App) suspendUser(suspension Suspension) (string, error) {
+	suspension.UUID = uuid.New().String()
+	if suspension.Kind == "" {
+		suspension.Kind = "suspension"
+	}
 
-	err := a.DB.Table("Likes").First(&has_liked, "post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Error
-	if err == gorm.ErrRecordNotFound {
-		return nil
-	} else if err != nil {
-		return err
-	} else {
-		err := a.DB.Table("Likes").Where("post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Delete(&Like{}).Error
-		if err != nil {
-			return err
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if suspension.Kind == "ban" {
+			var auth Auth
+			err := tx.Table("Auth").First(&auth, "user_uuid = ?", suspension.UserUUID).Error
+			if err != nil {
+				return err
+			}
+			suspension.Email = auth.Email
+
+			err = tx.Table("Auth").Where("user_uuid = ?", suspension.UserUUID).Update("current_cookie", gorm.Expr("NULL")).Error
+			if err != nil {
+				return err
+			}
 		}
 
-		err = a.DB.Table("Posts").Where("UUID = ?", post.UUID).Update("likes", post.Likes - 1).Error
-		if err != nil {
-			return err
-		}
-	}
+		return tx.Table("Suspensions").Create(&suspension).Error
+	})
 
-	return nil
+	return suspension.UUID, err
 }
 
-func (a App) getLike(post Post, user User) (bool, error) {
-	var has_liked Like
-
-	err := a.DB.Table("Likes").First(&has_liked, "post_uuid = ? AND user_uuid = ?", post.UUID, user.UUID).Error
-	if err == gorm.ErrRecordNotFound {
-		return false, nil
-	} else if err != nil {
-		return false, err
-	} else {
-		return true, nil
-	}
+// Ends a suspension or ban early
+func (a App) liftSuspension(UUID string) error {
+	return a.DB.Table("Suspensions").Where("uuid = ?", UUID).Updates(map[string]interface{}{
+		"expires_at": time.Now(),
+		"permanent": false,
+	}).Error
 }
 
-// Limit for how many top posts to get
-//
-// offset for pagination
-func (a App) getTopPosts(Limit int, Offset int) ([]Post, error) {
-	var posts []Post
+func (a App) getSuspensionByUUID(UUID string) (Suspension, error) {
+	var suspension Suspension
 
-	err := a.DB.Table("Posts").Offset(Offset).Limit(Limit).Order("likes DESC").Find(&posts).Error
+	err := a.DB.Table("Suspensions").First(&suspension, "uuid = ?", UUID).Error
 
-	return posts, err
+	return suspension, err
 }
 
-func (a App) createComment(comment Comment) (string, error) {
-	id := uuid.New()
-	comment.UUID = id.String()
+// Suspensions and bans still in force, as a query to narrow further
+func (a App) activeSuspensions() *gorm.DB {
+	return a.DB.Table("Suspensions").Where("expires_at > ? OR permanent = ?", time.Now(), true)
+}
 
-	err := a.DB.Table("Comments").Create(&comment).Error
+// The sanction in force the longest, bans before suspensions, zero if there isn't one
+func (a App) getActiveSuspension(user User) (Suspension, error) {
+	var suspensions []Suspension
 
-	if err != nil {
-		return "", err
+	err := a.activeSuspensions().Where("user_uuid = ?", user.UUID).
+		Order("kind = 'ban' DESC, permanent DESC, expires_at DESC").Limit(1).Find(&suspensions).Error
+	if err != nil || len(suspensions) == 0 {
+		return Suspension{}, err
 	}
 
-	err = a.DB.Table("Posts").Where("UUID = ?", comment.PostUUID).UpdateColumn("Comments", gorm.Expr("Comments + ?", 1)).Error
+	return suspensions[0], nil
+}
 
-	if err != nil {
-		return "", err
+// Every suspension and ban the user has had, newest first
+func (a App) getSuspensions(user User) ([]Suspension, error) {
+	var suspensions []Suspension
+
+	err := a.DB.Table("Suspensions").Where("user_uuid = ?", user.UUID).Order("created_at DESC").Find(&suspensions).Error
+
+	return suspensions, err
+}
+
+// The ban in force on the account, zero if there isn't one
+func (a App) getActiveBan(user User) (Suspension, error) {
+	var bans []Suspension
+
+	err := a.activeSuspensions().Where("user_uuid = ? AND kind = ?", user.UUID, "ban").Limit(1).Find(&bans).Error
+	if err != nil || len(bans) == 0 {
+		return Suspension{}, err
 	}
 
-	return comment.UUID, nil
+	return bans[0], nil
 }
 
-func (a App) getCommentsByPost(post Post, Limit int, Offset int) ([]Comment, error) {
-	var comments []Comment
+// Whether a ban in force was handed to an account with this email
+func (a App) emailBanned(Email string) (bool, error) {
+	var count int64
 
-	err := a.DB.Table("Comments").Where("post_uuid = ?", post.UUID).Offset(Offset).Limit(Limit).Find(&comments).Error
+	err := a.activeSuspensions().Where("kind = ? AND email = ?", "ban", Email).Count(&count).Error
 
-	return comments, err
+	return count > 0, err
 }
 
-func (a App) getCommentByUUID(UUID string) (Comment, error) {
-	var comment Comment
+func (a App) isSuspended(user User) bool {
+	suspension, err := a.getActiveSuspension(user)
 
-	err := a.DB.Table("Comments").First(&comment, "uuid = ?", UUID).Error
+	return err == nil && suspension.UUID != ""
+}
 
-	return comment, err
+func (a App) recordAudit(entry AuditEntry) (string, error) {
+	entry.UUID = uuid.New().String()
+
+	err := a.DB.Table("AuditLog").Create(&entry).Error
+
+	return entry.UUID, err
+}
+
+// Newest first, Query matches the actor, target, reason or snapshot and Action narrows to one kind
+//
+// a negative Limit returns everything, for exports
+func (a App) searchAuditLog(Query string, Action string, Limit int, Offset int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+
+	query := a.DB.Table("AuditLog")
+	if Query != "" {
+		like := "%" + Query + "%"
+		query = query.Where("actor_name LIKE ? OR actor_uuid = ? OR target_uuid = ? OR reason LIKE ? OR snapshot LIKE ?",
+			like, Query, Query, like, like)
+	}
+	if Action != "" {
+		query = query.Where("action = ?", Action)
+	}
+
+	err := query.Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&entries).Error
+
+	return entries, err
+}
+
+// Every action that has been logged at least once, for the filter
+func (a App) getAuditActions() ([]string, error) {
+	var actions []string
+
+	err := a.DB.Table("AuditLog").Distinct().Order("action ASC").Pluck("action", &actions).Error
+
+	return actions, err
 }
 
 // Auth functions
@@ -287,4 +2672,279 @@ func (a App) logOut(Cookie string) error {
 	err := a.DB.Table("Auth").Where("current_cookie = ?", Cookie).Update("current_cookie", gorm.Expr("NULL")).Error
 
 	return err
-}
\ No newline at end of file
+}
+func (a App) createAutomodRule(rule AutomodRule) (string, error) {
+	rule.UUID = uuid.New().String()
+
+	err := a.DB.Table("AutomodRules").Create(&rule).Error
+
+	return rule.UUID, err
+}
+
+func (a App) getAutomodRule(UUID string) (AutomodRule, error) {
+	var rule AutomodRule
+
+	err := a.DB.Table("AutomodRules").First(&rule, "uuid = ?", UUID).Error
+
+	return rule, err
+}
+
+// Every rule oldest first, or only the enabled ones that apply to TargetType when it isn't empty
+func (a App) getAutomodRules(TargetType string) ([]AutomodRule, error) {
+	var rules []AutomodRule
+
+	query := a.DB.Table("AutomodRules")
+	if TargetType != "" {
+		query = query.Where("enabled = ? AND (applies = '' OR applies = ?)", true, TargetType)
+	}
+
+	err := query.Order("created_at ASC").Find(&rules).Error
+
+	return rules, err
+}
+
+func (a App) setAutomodRuleEnabled(rule AutomodRule, Enabled bool) error {
+	return a.DB.Table("AutomodRules").Where("uuid = ?", rule.UUID).Update("enabled", Enabled).Error
+}
+
+func (a App) deleteAutomodRule(rule AutomodRule) error {
+	return a.DB.Table("AutomodRules").Where("uuid = ?", rule.UUID).Delete(&AutomodRule{}).Error
+}
+
+func (a App) recordAutomodHit(hit AutomodHit) (string, error) {
+	hit.UUID = uuid.New().String()
+
+	err := a.DB.Table("AutomodHits").Create(&hit).Error
+
+	return hit.UUID, err
+}
+
+// Newest first
+func (a App) getAutomodHits(Limit int, Offset int) ([]AutomodHit, error) {
+	var hits []AutomodHit
+
+	err := a.DB.Table("AutomodHits").Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&hits).Error
+
+	return hits, err
+}
+
+// How many posts or comments the user has made since, deleted and withheld ones included
+func (a App) countSubmissionsSince(user User, TargetType string, Since time.Time) (int64, error) {
+	var count int64
+
+	table := "Posts"
+	if TargetType == "comment" {
+		table = "Comments"
+	}
+
+	err := a.DB.Table(table).Where("user_uuid = ? AND created_at > ?", user.UUID, Since).Count(&count).Error
+
+	return count, err
+}
+
+// Held and shadow-hidden posts, oldest first so the longest waiting get seen first
+func (a App) getWithheldPosts(Limit int, Offset int) ([]Post, error) {
+	var posts []Post
+
+	err := a.DB.Table("Posts").Where("automod <> '' AND deleted = ?", false).
+		Order("created_at ASC").Offset(Offset).Limit(Limit).Find(&posts).Error
+
+	return posts, err
+}
+
+func (a App) getWithheldComments(Limit int, Offset int) ([]Comment, error) {
+	var comments []Comment
+
+	err := a.DB.Table("Comments").Where("automod <> '' AND deleted = ?", false).
+		Order("created_at ASC").Offset(Offset).Limit(Limit).Find(&comments).Error
+
+	return comments, err
+}
+
+func (a App) publishPost(post Post) error {
+	return a.DB.Table("Posts").Where("uuid = ?", post.UUID).UpdateColumn("automod", "").Error
+}
+
+// Clears the comment and counts it on its post like createComment would have
+func (a App) publishComment(comment Comment) error {
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table("Comments").Where("uuid = ?", comment.UUID).UpdateColumn("automod", "").Error
+		if err != nil {
+			return err
+		}
+
+		counted, err := countsOnPosts(tx, comment.UserUUID)
+		if err != nil || !counted {
+			return err
+		}
+
+		return tx.Table("Posts").Where("uuid = ?", comment.PostUUID).UpdateColumn("comments", gorm.Expr("comments + ?", 1)).Error
+	})
+}
+
+// Admin console functions
+
+// Users whose handle or UUID is Key, for filtering the console by user
+func (a App) adminUserKey(Key string) *gorm.DB {
+	return a.DB.Table("Users").Select("uuid").Where("uuid = ? OR handle = ?", Key, strings.TrimPrefix(Key, "@"))
+}
+
+// Narrows query to rows created inside the search's dates
+func adminDates(query *gorm.DB, search AdminSearch) *gorm.DB {
+	if !search.From.IsZero() {
+		query = query.Where("julianday(created_at) >= julianday(?)", search.From)
+	}
+	if !search.To.IsZero() {
+		query = query.Where("julianday(created_at) < julianday(?)", search.To.AddDate(0, 0, 1))
+	}
+
+	return query
+}
+
+// Posts and comments share their statuses
+func adminContentStatus(query *gorm.DB, Status string) *gorm.DB {
+	switch Status {
+	case "active":
+		return query.Where("deleted = ? AND automod = ''", false)
+	case "deleted":
+		return query.Where("deleted = ?", true)
+	case "held", "hidden":
+		return query.Where("deleted = ? AND automod = ?", false, Status)
+	}
+
+	return query
+}
+
+// A page of users, deleted ones included, matching the search
+func (a App) searchUsers(search AdminSearch) ([]User, error) {
+	var users []User
+
+	query := a.DB.Table("Users")
+	if search.Query != "" {
+		like := "%" + search.Query + "%"
+		query = query.Where("name LIKE ? OR handle LIKE ? OR bio LIKE ? OR uuid = ?", like, like, like, search.Query)
+	}
+	if search.User != "" {
+		query = query.Where("uuid IN (?)", a.adminUserKey(search.User))
+	}
+
+	switch search.Status {
+	case "active":
+		query = query.Where("deleted = ?", false)
+	case "deleted":
+		query = query.Where("deleted = ?", true)
+	case "suspended":
+		query = query.Where("uuid IN (?)", a.activeSuspensions().Select("user_uuid").Where("kind <> ?", "ban"))
+	case "banned":
+		query = query.Where("uuid IN (?)", a.activeSuspensions().Select("user_uuid").Where("kind = ?", "ban"))
+	case "shadowbanned":
+		query = query.Where("shadowbanned = ?", true)
+	case "moderator":
+		query = query.Where("moderator = ?", true)
+	}
+
+	err := adminDates(query, search).Order(search.order()).
+		Offset(search.Page * adminPageSize).Limit(adminPageSize).Find(&users).Error
+
+	return users, err
+}
+
+// A page of posts, deleted and withheld ones included, matching the search
+func (a App) searchPosts(search AdminSearch) ([]Post, error) {
+	var posts []Post
+
+	query := a.DB.Table("Posts")
+	if search.Query != "" {
+		like := "%" + search.Query + "%"
+		query = query.Where("title LIKE ? OR description LIKE ? OR lift LIKE ? OR uuid = ?", like, like, like, search.Query)
+	}
+	if search.User != "" {
+		query = query.Where("user_uuid IN (?)", a.adminUserKey(search.User))
+	}
+
+	err := adminDates(adminContentStatus(query, search.Status), search).Order(search.order()).
+		Offset(search.Page * adminPageSize).Limit(adminPageSize).Find(&posts).Error
+
+	return posts, err
+}
+
+// A page of comments, deleted and withheld ones included, matching the search
+func (a App) searchComments(search AdminSearch) ([]Comment, error) {
+	var comments []Comment
+
+	query := a.DB.Table("Comments")
+	if search.Query != "" {
+		query = query.Where("content LIKE ? OR uuid = ? OR post_uuid = ?", "%" + search.Query + "%", search.Query, search.Query)
+	}
+	if search.User != "" {
+		query = query.Where("user_uuid IN (?)", a.adminUserKey(search.User))
+	}
+
+	err := adminDates(adminContentStatus(query, search.Status), search).Order(search.order()).
+		Offset(search.Page * adminPageSize).Limit(adminPageSize).Find(&comments).Error
+
+	return comments, err
+}
+
+// A page of individual reports matching the search, the user filter matches who filed them and who they're about
+func (a App) searchReports(search AdminSearch) ([]Report, error) {
+	var reports []Report
+
+	query := a.DB.Table("Reports")
+	if search.Query != "" {
+		query = query.Where("details LIKE ? OR category = ? OR target_uuid = ?", "%" + search.Query + "%", search.Query, search.Query)
+	}
+	if search.User != "" {
+		users := a.adminUserKey(search.User)
+		query = query.Where("reporter_uuid IN (?) OR target_uuid IN (?) OR target_uuid IN (?) OR target_uuid IN (?)", users, users,
+			a.DB.Table("Posts").Select("uuid").Where("user_uuid IN (?)", users),
+			a.DB.Table("Comments").Select("uuid").Where("user_uuid IN (?)", users))
+	}
+
+	switch search.Status {
+	case "open":
+		query = query.Where("resolved = ?", false)
+	case "resolved":
+		query = query.Where("resolved = ?", true)
+	}
+
+	err := adminDates(query, search).Order(search.order()).
+		Offset(search.Page * adminPageSize).Limit(adminPageSize).Find(&reports).Error
+
+	return reports, err
+}
+
+// Deleted or not, for the console's user page
+func (a App) getAnyUserByUUID(UUID string) (User, error) {
+	var user User
+
+	err := a.DB.Table("Users").First(&user, "uuid = ?", UUID).Error
+
+	return user, err
+}
+
+func (a App) getEmail(user User) (string, error) {
+	var auth Auth
+
+	err := a.DB.Table("Auth").First(&auth, "user_uuid = ?", user.UUID).Error
+
+	return auth.Email, err
+}
+
+// Reactions the user has left, newest first
+func (a App) getReactionsByUser(user User, Limit int, Offset int) ([]Like, error) {
+	var likes []Like
+
+	err := a.DB.Table("Likes").Where("user_uuid = ?", user.UUID).Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&likes).Error
+
+	return likes, err
+}
+
+// Newest first
+func (a App) getAutomodHitsByUser(user User, Limit int, Offset int) ([]AutomodHit, error) {
+	var hits []AutomodHit
+
+	err := a.DB.Table("AutomodHits").Where("user_uuid = ?", user.UUID).Order("created_at DESC").Offset(Offset).Limit(Limit).Find(&hits).Error
+
+	return hits, err
+}
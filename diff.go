@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a run of words that was kept, removed ("del") or added ("ins")
+type DiffPart struct {
+	Text string
+	Op string
+}
+
+type FieldDiff struct {
+	Field string
+	Parts []DiffPart
+}
+
+// one edit on the history page, what changed going from the version before it
+type RevisionDiff struct {
+	EditorUUID string
+	EditedAt time.Time
+	Fields []FieldDiff
+}
+
+// Word level diff using the longest common subsequence of the two texts
+func diffWords(before string, after string) []DiffPart {
+	a := strings.Fields(before)
+	b := strings.Fields(after)
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var parts []DiffPart
+	add := func(word string, op string) {
+		if len(parts) > 0 && parts[len(parts)-1].Op == op {
+			parts[len(parts)-1].Text += " " + word
+			return
+		}
+		parts = append(parts, DiffPart{Text: word, Op: op})
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add(a[i], "")
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add(a[i], "del")
+			i++
+		default:
+			add(b[j], "ins")
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add(a[i], "del")
+	}
+	for ; j < len(b); j++ {
+		add(b[j], "ins")
+	}
+
+	return parts
+}
+
+// Diffs every field that changed between two versions of a post or comment
+func diffRevisions(before Revision, after Revision) []FieldDiff {
+	fields := []struct {
+		name string
+		before string
+		after string
+	}{
+		{"Title", before.Title, after.Title},
+		{"Description", before.Description, after.Description},
+		{"Weight", strconv.Itoa(before.Weight), strconv.Itoa(after.Weight)},
+		{"Reps", strconv.Itoa(before.Reps), strconv.Itoa(after.Reps)},
+		{"Sets", strconv.Itoa(before.Sets), strconv.Itoa(after.Sets)},
+		{"Lift", before.Lift, after.Lift},
+		{"Content", before.Content, after.Content},
+	}
+
+	var diffs []FieldDiff
+	for _, field := range fields {
+		if field.before == field.after {
+			continue
+		}
+		diffs = append(diffs, FieldDiff{Field: field.name, Parts: diffWords(field.before, field.after)})
+	}
+
+	return diffs
+}
+
+// Turns the stored revisions plus the live version into a list of edits, oldest first
+func revisionHistory(revisions []Revision, current Revision) []RevisionDiff {
+	history := make([]RevisionDiff, 0, len(revisions))
+
+	for i, revision := range revisions {
+		next := current
+		if i+1 < len(revisions) {
+			next = revisions[i+1]
+		}
+
+		history = append(history, RevisionDiff{
+			EditorUUID: revision.EditorUUID,
+			EditedAt: revision.CreatedAt,
+			Fields: diffRevisions(revision, next),
+		})
+	}
+
+	return history
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// nobody is filming a lift longer than this
+const maxTimestamp = 24 * 60 * 60
+
+var errBadTimestamp = errors.New("timestamps look like 12, 0:12 or 1:02:03.5")
+var errBadRegion = errors.New("regions are x,y,width,height as percents of the frame")
+
+// Reads "12.5", "0:12.5" or "1:00:12.5" as seconds, "" means no timestamp
+func parseTimestamp(text string) (float64, bool, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, false, nil
+	}
+
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return 0, false, errBadTimestamp
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+			return 0, false, errBadTimestamp
+		}
+		// minutes and seconds after the first field roll over at 60
+		if i > 0 && n >= 60 {
+			return 0, false, errBadTimestamp
+		}
+		seconds = seconds*60 + n
+	}
+
+	if seconds > maxTimestamp {
+		return 0, false, errBadTimestamp
+	}
+
+	return seconds, true, nil
+}
+
+// Reads "x,y,w,h" in percent of the frame, "" means no region
+func parseRegion(text string) ([4]float64, bool, error) {
+	var region [4]float64
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return region, false, nil
+	}
+
+	parts := strings.Split(text, ",")
+	if len(parts) != 4 {
+		return region, false, errBadRegion
+	}
+
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || n < 0 || n > 100 {
+			return region, false, errBadRegion
+		}
+		region[i] = n
+	}
+
+	if region[2] == 0 || region[3] == 0 || region[0]+region[2] > 100 || region[1]+region[3] > 100 {
+		return region, false, errBadRegion
+	}
+
+	return region, true, nil
+}
+
+// m:ss or h:mm:ss for the link the player seeks from
+func (c Comment) TimestampLabel() string {
+	total := int(c.Timestamp)
+	h, m, s := total/3600, total/60%60, total%60
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+func (c Comment) HasRegion() bool {
+	return c.Timed && c.RegionW > 0 && c.RegionH > 0
+}
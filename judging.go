@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// a panel is always three referees, two white lights make a good lift
+const judgingSeats = 3
+
+var errSeatsFull = errors.New("all three judging seats are taken")
+
+// the reasons a referee can give a red light
+var faultCodes = []struct {
+	Code string
+	Label string
+}{
+	{"turn", "Didn't wait for the command or turned the bar early"},
+	{"depth", "Missed depth"},
+	{"lockout", "Missed lockout"},
+}
+
+// Checks a light and its faults, a red light needs at least one fault and a white light none
+func parseLight(Light string, faults []string) (string, error) {
+	known := make(map[string]bool)
+	for _, fault := range faultCodes {
+		known[fault.Code] = true
+	}
+
+	var codes []string
+	seen := make(map[string]bool)
+	for _, fault := range faults {
+		if !known[fault] {
+			return "", errors.New("unknown fault " + fault)
+		}
+		if !seen[fault] {
+			seen[fault] = true
+			codes = append(codes, fault)
+		}
+	}
+
+	switch {
+	case Light == "white" && len(codes) == 0:
+	case Light == "red" && len(codes) > 0:
+	case Light == "white":
+		return "", errors.New("white lights can't carry faults")
+	case Light == "red":
+		return "", errors.New("give at least one fault with a red light")
+	default:
+		return "", errors.New("lights are white or red")
+	}
+
+	return strings.Join(codes, ","), nil
+}
+
+// good or nolift once every seat has a light, "" while the panel is still out
+func judgingResult(judgements []Judgement) string {
+	whites := 0
+	for _, judgement := range judgements {
+		if judgement.Light == "" {
+			return ""
+		}
+		if judgement.Light == "white" {
+			whites++
+		}
+	}
+	if len(judgements) < judgingSeats {
+		return ""
+	}
+
+	if whites*2 > judgingSeats {
+		return "good"
+	}
+	return "nolift"
+}
+
+func (j Judgement) FaultList() []string {
+	if j.Faults == "" {
+		return nil
+	}
+	return strings.Split(j.Faults, ",")
+}
+
+// percent of published lifts where the judge's light matched the panel, 0 before they've judged any
+func (s JudgeStats) Accuracy() int {
+	if s.Judged == 0 {
+		return 0
+	}
+	return s.Agreed * 100 / s.Judged
+}
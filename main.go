@@ -1,13 +1,17 @@
 package main
 
 import (
+	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
-	"io"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -44,6 +48,9 @@ func (a App) genAppState(r *http.Request) ApplicationState {
 		data.Moderator = user.Moderator
 		data.UserName = user.Name
 		data.Cookie = cookie.Value
+		data.Unread, _ = app.countUnreadNotifications(user)
+		data.UnreadMessages, _ = app.countUnreadMessages(user)
+		data.Suspension, _ = app.getActiveSuspension(user)
 	} else {
 		data.SignedIn = false
 	}
@@ -51,10 +58,38 @@ func (a App) genAppState(r *http.Request) ApplicationState {
 	return data
 }
 
+// Fills in the viewer's reaction, bookmark and ownership on each post, signed out viewers get none
+func (a App) decoratePosts(posts []Post, appstate ApplicationState) {
+	if !appstate.SignedIn {
+		return
+	}
+
+	user := User{UUID: appstate.UUID}
+	for i, post := range posts {
+		reaction, err := a.getReaction(post, user)
+		if err != nil {
+			fmt.Println("Failed to get reaction")
+		}
+		post.Reaction = reaction
+		bookmarked, err := a.isBookmarked(post, user)
+		if err != nil {
+			fmt.Println("Failed to get bookmark")
+		}
+		post.Bookmarked = bookmarked
+		post.Owner = post.UserUUID == appstate.UUID || appstate.Moderator
+		posts[i] = post
+	}
+}
+
 var app App
 
 func main() {
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	runGC := flag.Bool("gc", false, "collect unreferenced media once and exit")
+	gcGrace := flag.Duration("gc-grace", 24 * time.Hour, "how long media must be unreferenced before it is collected")
+	retention := flag.Duration("retention", 30 * 24 * time.Hour, "how long deleted posts, comments and users can be restored before they are purged")
+	flag.Parse()
+
+	db, err := gorm.Open(sqlite.Dialector{DriverName: phashDriver, DSN: "test.db"}, &gorm.Config{})
 	if err != nil {
 		panic("couldn't open DB")
 	}
@@ -65,19 +100,98 @@ func main() {
 	app.DB.Table("Posts").AutoMigrate(&Post{})
 	app.DB.Table("Auth").AutoMigrate(&Auth{})
 	app.DB.Table("Likes").AutoMigrate(&Like{})
+	if err := app.migrateReactions(); err != nil {
+		fmt.Println("Failed to migrate likes to reactions:", err)
+	}
 	app.DB.Table("Comments").AutoMigrate(&Comment{})
+	if err := app.backfillTimestamps(); err != nil {
+		fmt.Println("Failed to backfill timestamps:", err)
+	}
+	if err := app.backfillDeleted(); err != nil {
+		fmt.Println("Failed to backfill deleted flags:", err)
+	}
+	if err := app.backfillAutomod(); err != nil {
+		fmt.Println("Failed to backfill automod flags:", err)
+	}
+	app.DB.Table("Follows").AutoMigrate(&Follow{})
+	app.DB.Table("Blocks").AutoMigrate(&Block{})
+	app.DB.Table("AutomodRules").AutoMigrate(&AutomodRule{})
+	app.DB.Table("AutomodHits").AutoMigrate(&AutomodHit{})
+	app.DB.Table("Revisions").AutoMigrate(&Revision{})
+	app.DB.Table("Notifications").AutoMigrate(&Notification{})
+	app.DB.Table("NotificationPreferences").AutoMigrate(&NotificationPreference{})
+	app.DB.Table("Mentions").AutoMigrate(&Mention{})
+	app.DB.Table("Tags").AutoMigrate(&Tag{})
+	app.DB.Table("Conversations").AutoMigrate(&Conversation{})
+	app.DB.Table("ConversationMembers").AutoMigrate(&ConversationMember{})
+	app.DB.Table("Messages").AutoMigrate(&Message{})
+	app.DB.Table("MessageReports").AutoMigrate(&MessageReport{})
+	app.DB.Table("Reports").AutoMigrate(&Report{})
+	app.DB.Table("Suspensions").AutoMigrate(&Suspension{})
+	app.DB.Table("AuditLog").AutoMigrate(&AuditEntry{})
+	app.DB.Table("Media").AutoMigrate(&Media{})
+	app.DB.Table("DuplicateFlags").AutoMigrate(&DuplicateFlag{})
+	app.DB.Table("BannedMedia").AutoMigrate(&BannedMedia{})
+	app.DB.Table("Gyms").AutoMigrate(&Gym{})
+	app.DB.Table("GymMembers").AutoMigrate(&GymMember{})
+	app.DB.Table("Challenges").AutoMigrate(&Challenge{})
+	app.DB.Table("Badges").AutoMigrate(&Badge{})
+	app.DB.Table("VerificationVotes").AutoMigrate(&VerificationVote{})
+	app.DB.Table("Judgements").AutoMigrate(&Judgement{})
+	app.DB.Table("Collections").AutoMigrate(&Collection{})
+	app.DB.Table("Bookmarks").AutoMigrate(&Bookmark{})
+
+	os.MkdirAll(mediaDir, 0755)
+	if err := app.migrateLegacyMedia(); err != nil {
+		fmt.Println("Failed to migrate legacy uploads:", err)
+	}
+
+	if *runGC {
+		removed, reclaimed, err := app.collectMedia(*gcGrace)
+		if err != nil {
+			fmt.Println("Media GC failed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d blobs, reclaimed %d bytes\n", removed, reclaimed)
+		return
+	}
+	go app.mediaCollector(time.Hour, *gcGrace)
+	go app.challengeCloser(time.Minute)
+	go app.purger(time.Hour, *retention)
 
 	postcard := "layout/templates/postcard.html"
 	topbar := "layout/templates/topbar.html"
 
-    tmplFrontPage := template.Must(template.ParseFiles("layout/frontpage/index.html", postcard, topbar))
-    tmplPost := template.Must(template.ParseFiles("layout/post/post.html", postcard, topbar))
-    tmplUser := template.Must(template.ParseFiles("layout/user/user.html", postcard, topbar))
-    tmplSubmit := template.Must(template.ParseFiles("layout/upload/submit.html", postcard, topbar))
-    tmplLogin := template.Must(template.ParseFiles("layout/upload/login.html", postcard, topbar))
-    tmplNotFound := template.Must(template.ParseFiles("layout/404.html", postcard, topbar))
-	tmplSignUp := template.Must(template.ParseFiles("layout/upload/signup.html", postcard, topbar))
-	tmplAdmin := template.Must(template.ParseFiles("layout/admin/admin.html", postcard, topbar))
+    tmplFrontPage := template.Must(parseTemplate("layout/frontpage/index.html", postcard, topbar))
+    tmplPost := template.Must(parseTemplate("layout/post/post.html", postcard, topbar))
+    tmplUser := template.Must(parseTemplate("layout/user/user.html", postcard, topbar))
+    tmplSubmit := template.Must(parseTemplate("layout/upload/submit.html", postcard, topbar))
+    tmplLogin := template.Must(parseTemplate("layout/upload/login.html", postcard, topbar))
+    tmplNotFound := template.Must(parseTemplate("layout/404.html", postcard, topbar))
+	tmplSignUp := template.Must(parseTemplate("layout/upload/signup.html", postcard, topbar))
+	tmplEdit := template.Must(parseTemplate("layout/upload/edit.html", postcard, topbar))
+	tmplHistory := template.Must(parseTemplate("layout/admin/history.html", postcard, topbar))
+	tmplNotifications := template.Must(parseTemplate("layout/user/notifications.html", postcard, topbar))
+	tmplTag := template.Must(parseTemplate("layout/tag/tag.html", postcard, topbar))
+	tmplGyms := template.Must(parseTemplate("layout/gym/gyms.html", postcard, topbar))
+	tmplGym := template.Must(parseTemplate("layout/gym/gym.html", postcard, topbar))
+	tmplChallenges := template.Must(parseTemplate("layout/challenge/challenges.html", postcard, topbar))
+	tmplChallenge := template.Must(parseTemplate("layout/challenge/challenge.html", postcard, topbar))
+	tmplBlocked := template.Must(parseTemplate("layout/user/blocked.html", postcard, topbar))
+	tmplCollections := template.Must(parseTemplate("layout/user/collections.html", postcard, topbar))
+	tmplCollection := template.Must(parseTemplate("layout/user/collection.html", postcard, topbar))
+	tmplInbox := template.Must(parseTemplate("layout/messages/inbox.html", postcard, topbar))
+	tmplConversation := template.Must(parseTemplate("layout/messages/conversation.html", postcard, topbar))
+	tmplTrash := template.Must(parseTemplate("layout/user/trash.html", postcard, topbar))
+	tmplAdminAudit := template.Must(parseTemplate("layout/admin/audit.html", postcard, topbar))
+	tmplAdminAutomod := template.Must(parseTemplate("layout/admin/automod.html", postcard, topbar))
+	tmplAdminUser := template.Must(parseTemplate("layout/admin/user.html", postcard, topbar))
+	tmplAdminReports := template.Must(parseTemplate("layout/admin/reports.html", postcard, topbar))
+	tmplReport := template.Must(parseTemplate("layout/upload/report.html", postcard, topbar))
+	tmplAdminMessages := template.Must(parseTemplate("layout/admin/messages.html", postcard, topbar))
+	tmplReactions := template.Must(parseTemplate("layout/post/reactions.html", postcard, topbar))
+	tmplAdmin := template.Must(parseTemplate("layout/admin/admin.html", postcard, topbar))
+	tmplAdminMedia := template.Must(parseTemplate("layout/admin/media.html", postcard, topbar))
 
 	r := mux.NewRouter()
 	app.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -94,38 +208,68 @@ func main() {
 	http.Handle("/", r)
 
     r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		best, err := app.getTopPosts(10, 0)
-		
-		if err != nil {
-			panic(err)
-		}
-
 		appstate := app.genAppState(r)
+		query := r.URL.Query()
+		feed := query.Get("feed")
+		sort := query.Get("sort")
+		period := query.Get("t")
+
+		var best []Post
+		var next url.Values
+		var err error
+
+		if feed == "following" {
+			if !appstate.SignedIn {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
 
-		if appstate.SignedIn {
-			user, err := app.getUserByUUID(appstate.UUID)
+			before, _ := strconv.ParseInt(query.Get("before"), 10, 64)
+			best, err = app.getFollowingFeed(User{UUID: appstate.UUID}, 10, before)
 			if err != nil {
-				fmt.Println("Failed to create user object from UUID")
+				best = make([]Post, 0)
+			}
+			if len(best) == 10 {
+				next = url.Values{"feed": {feed}, "before": {strconv.FormatInt(best[len(best)-1].Seq, 10)}}
+			}
+		} else {
+			feed = "global"
+			if sort != "new" && sort != "hot" {
+				sort = "top"
+			}
+			if _, ok := feedPeriods[period]; !ok || sort != "top" {
+				period = "all"
+			}
 
+			page, _ := strconv.Atoi(query.Get("page"))
+			if page < 0 {
+				page = 0
 			}
-			for i, post := range best {
-				liked, err := app.getLike(post, user)
-				if err != nil {
-					fmt.Println("Failed to get like count")
-				}
-				post.Liked = liked
-				if post.UserUUID == appstate.UUID || appstate.Moderator {
-					post.Owner = true
-				} else {
-					post.Owner = false
-				}
-				best[i] = post
+
+			best, err = app.getFeed(User{UUID: appstate.UUID}, sort, period, 10, page * 10)
+			
+			if err != nil {
+				panic(err)
 			}
+			if len(best) == 10 {
+				next = url.Values{"sort": {sort}, "t": {period}, "page": {strconv.Itoa(page + 1)}}
+			}
+		}
+
+		app.decoratePosts(best, appstate)
+
+		var nextURL template.URL
+		if next != nil {
+			nextURL = template.URL("/?" + next.Encode())
 		}
 
 		data := map[string]interface{}{
 			"TopPosts": best,
-			"ApplicationState": app.genAppState(r),
+			"Feed": feed,
+			"Sort": sort,
+			"Period": period,
+			"Next": nextURL,
+			"ApplicationState": appstate,
 		}
 
 
@@ -135,535 +279,4149 @@ func main() {
 
 	r.HandleFunc("/post/{uuid}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
 
 		post, err := app.getPostByUUID(vars["uuid"])
 		if err != nil {
+			// deleted posts stay visible to whoever can bring them back
+			post, err = app.getDeletedPost(vars["uuid"])
+			if err != nil || !(appstate.Moderator || (appstate.SignedIn && post.UserUUID == appstate.UUID)) {
+				app.NotFoundHandler(w, r)
+				return
+			}
+		}
+
+		if !app.canSeePost(post, appstate) {
 			app.NotFoundHandler(w, r)
 			return
 		}
 
-		appstate := app.genAppState(r)
+		decorated := []Post{post}
+		app.decoratePosts(decorated, appstate)
+		post = decorated[0]
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		var comments []Comment
+		var thread Comment
+
+		if threadUUID := r.URL.Query().Get("thread"); threadUUID != "" {
+			// "continue thread" link, show that comment as the root
+			thread, err = app.getVisibleComment(threadUUID, User{UUID: appstate.UUID})
+			if err != nil || thread.PostUUID != post.UUID {
+				app.NotFoundHandler(w, r)
+				return
+			}
+			comments = []Comment{thread}
+		} else {
+			comments, err = app.getCommentsByPost(post, User{UUID: appstate.UUID}, 20, page * 20)
+			if err != nil {
+				comments = make([]Comment, 0)
+			}
+		}
+
+		replies, err := app.getRepliesByPost(post, User{UUID: appstate.UUID})
+		if err != nil {
+			replies = make([]Comment, 0)
+		}
+		comments = threadComments(post, comments, replies, appstate.UUID, appstate.Moderator)
+
+		// pinned above the thread so the answer is the first thing people read
+		var accepted Comment
+		if post.AcceptedUUID != "" && thread.UUID == "" {
+			accepted, err = app.getVisibleComment(post.AcceptedUUID, User{UUID: appstate.UUID})
+			if err == nil && !accepted.Deleted {
+				accepted = threadComments(post, []Comment{accepted}, nil, appstate.UUID, appstate.Moderator)[0]
+			} else {
+				accepted = Comment{}
+			}
+		}
+
+		nextPage := 0
+		if thread.UUID == "" && len(comments) == 20 {
+			nextPage = page + 1
+		}
 
+		var viewer User
 		if appstate.SignedIn {
-			user, err := app.getUserByUUID(appstate.UUID)
+			viewer, _ = app.getUserByUUID(appstate.UUID)
+		}
+
+		var votes []VerificationVote
+		if post.Verification != "" {
+			votes, err = app.getVerificationVotes(post)
 			if err != nil {
-				fmt.Println("Failed to create user object from UUID")
+				votes = make([]VerificationVote, 0)
 			}
-			liked, err := app.getLike(post, user)
+		}
+
+		var judgements []Judgement
+		if post.Judging != "" {
+			judgements, err = app.getJudgements(post)
 			if err != nil {
-				fmt.Println("Failed to get like count")
+				judgements = make([]Judgement, 0)
 			}
-			post.Liked = liked
-			if post.UserUUID == appstate.UUID || appstate.Moderator {
-				post.Owner = true
-			} else {
-				post.Owner = false
+		}
+
+		var seat Judgement
+		for _, judgement := range judgements {
+			if judgement.JudgeUUID == viewer.UUID {
+				seat = judgement
 			}
 		}
 
-		comments, err := app.getCommentsByPost(post, 10, 0)
-		if err != nil {
-			comments = make([]Comment, 0)
+		var myVote VerificationVote
+		for _, vote := range votes {
+			if vote.UserUUID == viewer.UUID {
+				myVote = vote
+			}
 		}
 
 		data := map[string]interface{}{
 			"Post": post,
 			"Comments": comments,
-			"ApplicationState": app.genAppState(r),
+			"Votes": votes,
+			"MyVote": myVote,
+			"CanVerify": app.canVerify(post, viewer),
+			"CanRequestVerification": post.Verification == "" && appstate.SignedIn && post.UserUUID == appstate.UUID,
+			"Judgements": judgements,
+			"Seat": seat,
+			"CanSit": post.Judging == "open" && appstate.SignedIn && post.UserUUID != appstate.UUID && seat.Seat == 0 && len(judgements) < judgingSeats,
+			"CanRequestJudging": post.Judging == "" && appstate.SignedIn && post.UserUUID == appstate.UUID,
+			"FaultCodes": faultCodes,
+			"Accepted": accepted,
+			"CanRestore": post.Deleted && canRestore(post.UserUUID, post.DeletedBy, post.DeletedAt, appstate, *retention),
+			"RestoreBy": restoreDeadline(post.DeletedAt, *retention),
+			"Thread": thread,
+			"NextPage": nextPage,
+			"ApplicationState": appstate,
 		}
 
 		tmplPost.Execute(w, data)
 
     })
 
+	r.HandleFunc("/reactions/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil || !app.canSeePost(post, appstate) {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		likes, err := app.getReactions(post)
+		if err != nil {
+			likes = make([]Like, 0)
+		}
+
+		type reactor struct {
+			Like Like
+			User User
+		}
+
+		byType := make(map[string][]reactor)
+		for _, like := range likes {
+			user, err := app.getUserByUUID(like.UserUUID)
+			if err != nil || !canSee(user, appstate) {
+				continue
+			}
+			byType[like.Type] = append(byType[like.Type], reactor{Like: like, User: user})
+		}
+
+		data := map[string]interface{}{
+			"Post": post,
+			"Reactors": byType,
+			"ApplicationState": appstate,
+		}
+
+		tmplReactions.Execute(w, data)
+	})
+
 	r.HandleFunc("/user/{uuid}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		appstate := app.genAppState(r)
 
 		page_user, err := app.getUserByUUID(vars["uuid"])
-		if err != nil {
+		if err != nil || !canSee(page_user, appstate) {
 			app.NotFoundHandler(w, r)
 			return
 		}
 
-		posts, err := app.getPostsByUser(page_user, 10, 0)
+		posts, err := app.getPostsByUser(page_user, User{UUID: appstate.UUID}, 10, 0)
 
 		if err != nil {
 			posts = make([]Post, 0)
 		}
 
+		app.decoratePosts(posts, appstate)
+
+		followers, following, err := app.getFollowCounts(page_user, User{UUID: appstate.UUID})
+		if err != nil {
+			fmt.Println("Failed to get follow counts")
+		}
+
+		var suspensions []Suspension
+		if appstate.Moderator {
+			suspensions, err = app.getSuspensions(page_user)
+			if err != nil {
+				suspensions = make([]Suspension, 0)
+			}
+		}
+
+		blockKind := ""
 		if appstate.SignedIn {
-			user, err := app.getUserByUUID(appstate.UUID)
+			blockKind, err = app.getBlockKind(User{UUID: appstate.UUID}, page_user)
 			if err != nil {
-				fmt.Println("Failed to create user object from UUID")
+				fmt.Println("Failed to get block status")
 			}
-			for i, post := range posts {
-				liked, err := app.getLike(post, user)
-				if err != nil {
-					fmt.Println("Failed to get like count")
-				}
-				post.Liked = liked
-				if post.UserUUID == appstate.UUID || appstate.Moderator {
-					post.Owner = true
-				} else {
-					post.Owner = false
-				}
-				posts[i] = post
+		}
+
+		isFollowing := false
+		if appstate.SignedIn {
+			isFollowing, err = app.isFollowing(User{UUID: appstate.UUID}, page_user)
+			if err != nil {
+				fmt.Println("Failed to get follow status")
 			}
 		}
 
+		gyms, err := app.getGymsByUser(page_user)
+		if err != nil {
+			gyms = make([]Gym, 0)
+		}
+
+		judgeStats, err := app.getJudgeStats(page_user)
+		if err != nil {
+			fmt.Println("Failed to get judge stats")
+		}
+
+		badges, err := app.getBadgesByUser(page_user)
+		if err != nil {
+			badges = make([]Badge, 0)
+		}
+
 		data := map[string]interface{}{
 			"User": page_user,
 			"Posts": posts,
+			"Badges": badges,
+			"JudgeStats": judgeStats,
+			"Gyms": gyms,
+			"Followers": followers,
+			"Following": following,
+			"IsFollowing": isFollowing,
+			"BlockKind": blockKind,
+			"Suspensions": suspensions,
+			"Now": time.Now(),
 			"ApplicationState": appstate,
 		}
 
 		tmplUser.Execute(w, data)
     })
 
-	r.HandleFunc("/upload/post/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/u/{handle}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
-		fileBytes, err := ioutil.ReadFile("upload/post/" + vars["uuid"])
+		user, err := app.getUserByHandle(vars["handle"])
 		if err != nil {
 			app.NotFoundHandler(w, r)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Write(fileBytes)
-    })
 
-	r.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth")
+		http.Redirect(w, r, "/user/" + user.UUID, http.StatusSeeOther)
+	})
+
+	r.HandleFunc("/tag/{name}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		posts, err := app.getPostsByTag(vars["name"], User{UUID: appstate.UUID}, 10, page * 10)
+
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Sign in to post")))
-			return
+			posts = make([]Post, 0)
 		}
-		if _, err := app.validateCookie(cookie.Value); err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Invalid cookie")))
-			return
+
+		app.decoratePosts(posts, appstate)
+
+		nextPage := 0
+		if len(posts) == 10 {
+			nextPage = page + 1
 		}
 
 		data := map[string]interface{}{
-			"ApplicationState": app.genAppState(r),
+			"Tag": strings.ToLower(vars["name"]),
+			"Posts": posts,
+			"NextPage": nextPage,
+			"ApplicationState": appstate,
 		}
 
-		tmplSubmit.Execute(w, data)
+		tmplTag.Execute(w, data)
 	})
 
-	r.HandleFunc("/likePost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth")
+	r.HandleFunc("/gyms", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		gyms, err := app.getGyms(20, page * 20)
 		if err != nil {
+			gyms = make([]Gym, 0)
+		}
+
+		nextPage := 0
+		if len(gyms) == 20 {
+			nextPage = page + 1
+		}
+
+		data := map[string]interface{}{
+			"Gyms": gyms,
+			"NextPage": nextPage,
+			"ApplicationState": appstate,
+		}
+
+		tmplGyms.Execute(w, data)
+	})
+
+	r.HandleFunc("/gyms/new", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		if !appstate.SignedIn {
 			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Sign in to like")))
+			w.Write([]byte("Sign in to add a gym"))
 			return
 		}
-		user, err := app.validateCookie(cookie.Value)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Invalid cookie")))
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
 			return
 		}
 
-		vars := mux.Vars(r)
-		post, err := app.getPostByUUID(vars["uuid"])
-
-		if err != nil {
+		gym := Gym{
+			Name: strings.TrimSpace(r.FormValue("name")),
+			Location: strings.TrimSpace(r.FormValue("location")),
+			Description: r.FormValue("description"),
+		}
+		if gym.Name == "" {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Provide a valid post to like"))
+			w.Write([]byte("Gyms need a name"))
 			return
 		}
 
-		err = app.likePost(post, user)
-
+		gymUUID, err := app.createGym(gym, User{UUID: appstate.UUID, Name: appstate.UserName})
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to like post"))
+			w.Write([]byte("Failed to create gym"))
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		http.Redirect(w, r, "/gym/" + gymUUID, http.StatusSeeOther)
 	}).Methods("POST")
 
-	r.HandleFunc("/removeLike/{uuid}", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth")
+	r.HandleFunc("/gym/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		gym, err := app.getGymByUUID(vars["uuid"])
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Sign in to remove like")))
+			app.NotFoundHandler(w, r)
 			return
 		}
-		user, err := app.validateCookie(cookie.Value)
+
+		var membership GymMember
+		if appstate.SignedIn {
+			membership, _ = app.getGymMember(gym, User{UUID: appstate.UUID})
+		}
+		isAdmin := (membership.Approved && membership.Admin) || appstate.Moderator
+
+		members, err := app.getGymMembers(gym, true)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Invalid cookie")))
-			return
+			members = make([]GymMember, 0)
 		}
 
-		vars := mux.Vars(r)
-		post, err := app.getPostByUUID(vars["uuid"])
+		var pending []GymMember
+		if isAdmin {
+			pending, err = app.getGymMembers(gym, false)
+			if err != nil {
+				pending = make([]GymMember, 0)
+			}
+		}
 
+		lifts, err := app.getGymLifts(gym, User{UUID: appstate.UUID})
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Provide a valid post to remove like"))
-			return
+			lifts = make([]string, 0)
 		}
 
-		err = app.removeLike(post, user)
+		verified := r.URL.Query().Get("verified") != ""
+		lift := strings.ToLower(r.URL.Query().Get("lift"))
+		if lift == "" && len(lifts) > 0 {
+			lift = lifts[0]
+		}
+
+		var leaderboard []LeaderboardEntry
+		if lift != "" {
+			leaderboard, err = app.getGymLeaderboard(gym, lift, verified, User{UUID: appstate.UUID}, 10)
+			if err != nil {
+				leaderboard = make([]LeaderboardEntry, 0)
+			}
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
 
+		posts, err := app.getPostsByGym(gym, User{UUID: appstate.UUID}, 10, page * 10)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to remove like"))
-			return
+			posts = make([]Post, 0)
 		}
 
-		w.WriteHeader(http.StatusNoContent)
-	}).Methods("POST")
+		app.decoratePosts(posts, appstate)
+
+		nextPage := 0
+		if len(posts) == 10 {
+			nextPage = page + 1
+		}
 
-	r.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
-			"ApplicationState": app.genAppState(r),
+			"Gym": gym,
+			"Membership": membership,
+			"IsAdmin": isAdmin,
+			"Members": members,
+			"Pending": pending,
+			"Lifts": lifts,
+			"Lift": lift,
+			"Verified": verified,
+			"Leaderboard": leaderboard,
+			"Posts": posts,
+			"NextPage": nextPage,
+			"ApplicationState": appstate,
 		}
-		tmplLogin.Execute(w, data)
+
+		tmplGym.Execute(w, data)
 	})
 
-	r.HandleFunc("/loginSubmit", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			w.WriteHeader(http.StatusNotFound)
+	r.HandleFunc("/gym/{uuid}/join", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to join a gym"))
 			return
 		}
-		handle := r.FormValue("handle")
-		password := r.FormValue("password")
 
-		user, err := app.getUserByHandle(handle)
+		gym, err := app.getGymByUUID(vars["uuid"])
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("User " + handle + " not found"))
+			app.NotFoundHandler(w, r)
 			return
 		}
 
-		cookie, err := app.signIn(user.UUID, password)
+		err = app.joinGym(gym, User{UUID: appstate.UUID, Name: appstate.UserName})
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Invalid credentials"))
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to join gym"))
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name: "auth",
-			Value: cookie,
-		})
-		
-		http.Redirect(w, r, "/user/" + user.UUID, http.StatusSeeOther)
+		http.Redirect(w, r, "/gym/" + gym.UUID, http.StatusSeeOther)
 	}).Methods("POST")
 
-	r.HandleFunc("/handleExists/{handle}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/gym/{uuid}/leave", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-
-		if vars["handle"] == "" {
-			w.WriteHeader(http.StatusBadRequest)
+		appstate := app.genAppState(r)
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to leave a gym"))
+			return
+		}
+
+		gym, err := app.getGymByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		member, err := app.getGymMember(gym, User{UUID: appstate.UUID})
+		if err != nil {
+			http.Redirect(w, r, "/gym/" + gym.UUID, http.StatusSeeOther)
+			return
+		}
+
+		if member.Admin {
+			admins, err := app.countGymAdmins(gym)
+			if err != nil || admins <= 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Make someone else an admin before leaving"))
+				return
+			}
+		}
+
+		err = app.removeGymMember(gym, member)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to leave gym"))
+			return
+		}
+
+		http.Redirect(w, r, "/gym/" + gym.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	// approve, reject (also kicks members), promote and demote, all gym admin only
+	r.HandleFunc("/gym/{uuid}/members/{action}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		gym, err := app.getGymByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.SignedIn || (!appstate.Moderator && !app.isGymAdmin(gym, User{UUID: appstate.UUID})) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only gym admins can manage members"))
+			return
+		}
+
+		member, err := app.getGymMember(gym, User{UUID: r.FormValue("user")})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid member"))
+			return
+		}
+
+		switch vars["action"] {
+		case "approve":
+			err = app.approveGymMember(gym, member)
+		case "reject":
+			if member.Admin {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Demote admins before removing them"))
+				return
+			}
+			err = app.removeGymMember(gym, member)
+		case "promote":
+			err = app.setGymAdmin(gym, member, true)
+		case "demote":
+			admins, countErr := app.countGymAdmins(gym)
+			if countErr != nil || admins <= 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Gyms need at least one admin"))
+				return
+			}
+			err = app.setGymAdmin(gym, member, false)
+		default:
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to update member"))
+			return
+		}
+
+		http.Redirect(w, r, "/gym/" + gym.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/gym/{uuid}/edit", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		gym, err := app.getGymByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.SignedIn || (!appstate.Moderator && !app.isGymAdmin(gym, User{UUID: appstate.UUID})) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only gym admins can edit the gym"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		edited := Gym{
+			Name: strings.TrimSpace(r.FormValue("name")),
+			Location: strings.TrimSpace(r.FormValue("location")),
+			Description: r.FormValue("description"),
+		}
+		if edited.Name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Gyms need a name"))
+			return
+		}
+
+		err = app.editGym(gym, edited)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to edit gym"))
+			return
+		}
+
+		http.Redirect(w, r, "/gym/" + gym.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/gym/{uuid}/delete", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		gym, err := app.getGymByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.SignedIn || (!appstate.Moderator && !app.isGymAdmin(gym, User{UUID: appstate.UUID})) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only gym admins can delete the gym"))
+			return
+		}
+
+		err = app.deleteGym(gym)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete gym"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "deleteGym", "gym", gym.UUID, r.FormValue("reason"), gym)
+
+		http.Redirect(w, r, "/gyms", http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/gym/{uuid}/removePost", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		gym, err := app.getGymByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.SignedIn || (!appstate.Moderator && !app.isGymAdmin(gym, User{UUID: appstate.UUID})) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only gym admins can moderate the gym feed"))
+			return
+		}
+
+		post, err := app.getPostByUUID(r.FormValue("post"))
+		if err != nil || post.GymUUID != gym.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a post from this gym"))
+			return
+		}
+
+		err = app.removePostFromGym(post)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to remove post"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "removeGymPost", "post", post.UUID, r.FormValue("reason"), post)
+
+		http.Redirect(w, r, "/gym/" + gym.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/challenges", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		open, err := app.getOpenChallenges()
+		if err != nil {
+			open = make([]Challenge, 0)
+		}
+
+		closed, err := app.getClosedChallenges(20, 0)
+		if err != nil {
+			closed = make([]Challenge, 0)
+		}
+
+		// gyms the viewer can run a challenge for, moderators can also run site wide ones
+		var gyms []Gym
+		if appstate.SignedIn {
+			memberships, err := app.getGymsByUser(User{UUID: appstate.UUID})
+			if err != nil {
+				memberships = make([]Gym, 0)
+			}
+			for _, gym := range memberships {
+				if app.isGymAdmin(gym, User{UUID: appstate.UUID}) {
+					gyms = append(gyms, gym)
+				}
+			}
+		}
+
+		data := map[string]interface{}{
+			"Open": open,
+			"Closed": closed,
+			"Gyms": gyms,
+			"Scoring": challengeScoring,
+			"ApplicationState": appstate,
+		}
+
+		tmplChallenges.Execute(w, data)
+	})
+
+	r.HandleFunc("/challenges/new", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to run a challenge"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		challenge := Challenge{
+			Title: strings.TrimSpace(r.FormValue("title")),
+			Description: r.FormValue("description"),
+			Tag: strings.ToLower(strings.TrimPrefix(strings.TrimSpace(r.FormValue("tag")), "#")),
+			Scoring: r.FormValue("scoring"),
+			Lift: strings.ToLower(strings.TrimSpace(r.FormValue("lift"))),
+			CreatorUUID: appstate.UUID,
+			CreatorName: appstate.UserName,
+		}
+		challenge.MinWeight, _ = strconv.Atoi(r.FormValue("minweight"))
+		challenge.VerifiedOnly = r.FormValue("verified") != ""
+
+		if gymUUID := r.FormValue("gym"); gymUUID != "" {
+			gym, err := app.getGymByUUID(gymUUID)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Provide a valid gym"))
+				return
+			}
+			challenge.GymUUID, challenge.GymName = gym.UUID, gym.Name
+		}
+
+		if !appstate.Moderator && (challenge.GymUUID == "" || !app.isGymAdmin(Gym{UUID: challenge.GymUUID}, User{UUID: appstate.UUID})) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only moderators and gym admins can run challenges"))
+			return
+		}
+
+		// dates are whole days, the end date is the last day you can enter on
+		starts, err := time.ParseInLocation("2006-01-02", r.FormValue("starts"), time.Local)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a start date"))
+			return
+		}
+		ends, err := time.ParseInLocation("2006-01-02", r.FormValue("ends"), time.Local)
+		if err != nil || ends.Before(starts) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide an end date on or after the start"))
+			return
+		}
+		challenge.StartsAt, challenge.EndsAt = starts, ends.AddDate(0, 0, 1)
+
+		// the tag has to be one the markup parser would pick out of a description
+		tags := parseTags("#" + challenge.Tag)
+		if challenge.Title == "" || len(tags) != 1 || tags[0] != challenge.Tag {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Challenges need a title and a tag made of letters, numbers and underscores"))
+			return
+		}
+		if _, ok := scoringExpr(challenge.Scoring); !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Pick how the challenge is scored"))
+			return
+		}
+
+		taken, err := app.challengeTagTaken(challenge.Tag)
+		if err != nil || taken {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("#" + challenge.Tag + " is already used by another challenge"))
+			return
+		}
+
+		challengeUUID, err := app.createChallenge(challenge)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to create challenge"))
+			return
+		}
+
+		http.Redirect(w, r, "/challenge/" + challengeUUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/challenge/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		challenge, err := app.getChallengeByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		standings, err := app.getStandings(challenge, User{UUID: appstate.UUID}, 50)
+		if err != nil {
+			standings = make([]Standing, 0)
+		}
+
+		posts, err := app.getChallengePosts(challenge, User{UUID: appstate.UUID}, 10, 0)
+		if err != nil {
+			posts = make([]Post, 0)
+		}
+
+		app.decoratePosts(posts, appstate)
+
+		canDelete := appstate.Moderator ||
+			(appstate.SignedIn && challenge.GymUUID != "" && app.isGymAdmin(Gym{UUID: challenge.GymUUID}, User{UUID: appstate.UUID}))
+
+		data := map[string]interface{}{
+			"Challenge": challenge,
+			"Standings": standings,
+			"Posts": posts,
+			"CanDelete": canDelete,
+			"ApplicationState": appstate,
+		}
+
+		tmplChallenge.Execute(w, data)
+	})
+
+	r.HandleFunc("/challenge/{uuid}/delete", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		challenge, err := app.getChallengeByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.Moderator &&
+			!(appstate.SignedIn && challenge.GymUUID != "" && app.isGymAdmin(Gym{UUID: challenge.GymUUID}, User{UUID: appstate.UUID})) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only moderators and the gym's admins can delete a challenge"))
+			return
+		}
+
+		err = app.deleteChallenge(challenge)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete challenge"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "deleteChallenge", "challenge", challenge.UUID, r.FormValue("reason"), challenge)
+
+		http.Redirect(w, r, "/challenges", http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/upload/post/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		appstate := app.genAppState(r)
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil || post.Automod != "" {
+			if err != nil {
+				post, err = app.getDeletedPost(vars["uuid"])
+			}
+			if err != nil || !(appstate.Moderator || (appstate.SignedIn && post.UserUUID == appstate.UUID)) {
+				app.NotFoundHandler(w, r)
+				return
+			}
+		}
+		if author, err := app.getUserByUUID(post.UserUUID); err == nil && !canSee(author, appstate) {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if err := serveMedia(w, r, post); err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+    })
+
+	r.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("auth")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Sign in to post")))
+			return
+		}
+		if _, err := app.validateCookie(cookie.Value); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Invalid cookie")))
+			return
+		}
+
+		appstate := app.genAppState(r)
+
+		gyms, err := app.getGymsByUser(User{UUID: appstate.UUID})
+		if err != nil {
+			gyms = make([]Gym, 0)
+		}
+
+		data := map[string]interface{}{
+			"Gyms": gyms,
+			"ApplicationState": appstate,
+		}
+
+		tmplSubmit.Execute(w, data)
+	})
+
+	r.HandleFunc("/likePost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("auth")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Sign in to like")))
+			return
+		}
+		user, err := app.validateCookie(cookie.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Invalid cookie")))
+			return
+		}
+
+		if app.refuseSuspended(w, user) {
+			return
+		}
+
+		vars := mux.Vars(r)
+		post, err := app.getPostByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid post to like"))
+			return
+		}
+
+		reaction := r.FormValue("type")
+		if reaction == "" {
+			reaction = "clap"
+		}
+		if _, ok := reactionColumns[reaction]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Unknown reaction " + reaction))
+			return
+		}
+
+		blocked, err := app.hasBlocked(User{UUID: post.UserUUID}, user)
+		if err != nil || blocked {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("You can't react to this post"))
+			return
+		}
+
+		err = app.likePost(post, user, reaction)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to like post"))
+			return
+		}
+
+		err = app.notify(Notification{
+			UserUUID: post.UserUUID,
+			Type: "like",
+			ActorUUID: user.UUID,
+			ActorName: user.Name,
+			TargetUUID: post.UUID,
+			PostUUID: post.UUID,
+		})
+		if err != nil {
+			fmt.Println("Failed to send like notification")
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+
+	r.HandleFunc("/removeLike/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("auth")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Sign in to remove like")))
+			return
+		}
+		user, err := app.validateCookie(cookie.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Invalid cookie")))
+			return
+		}
+
+		vars := mux.Vars(r)
+		post, err := app.getPostByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid post to remove like"))
+			return
+		}
+
+		err = app.removeLike(post, user)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to remove like"))
+			return
+		}
+
+		if err := app.unnotify("like", user.UUID, post.UUID); err != nil {
+			fmt.Println("Failed to take back like notification")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	// a collection's posts, or the unfiled ones when collection has no UUID
+	renderCollection := func(w http.ResponseWriter, r *http.Request, collection Collection, appstate ApplicationState) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
+
+		posts, err := app.getBookmarkedPosts(User{UUID: collection.UserUUID}, collection.UUID, User{UUID: appstate.UUID}, 10, page * 10)
+		if err != nil {
+			posts = make([]Post, 0)
+		}
+
+		app.decoratePosts(posts, appstate)
+
+		isOwner := appstate.SignedIn && appstate.UUID == collection.UserUUID
+
+		var collections []Collection
+		if isOwner {
+			collections, err = app.getCollectionsByUser(User{UUID: appstate.UUID}, true)
+			if err != nil {
+				collections = make([]Collection, 0)
+			}
+		}
+
+		owner, err := app.getUserByUUID(collection.UserUUID)
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		nextPage := 0
+		if len(posts) == 10 {
+			nextPage = page + 1
+		}
+
+		data := map[string]interface{}{
+			"Collection": collection,
+			"Owner": owner,
+			"IsOwner": isOwner,
+			"Collections": collections,
+			"Posts": posts,
+			"NextPage": nextPage,
+			"ApplicationState": appstate,
+		}
+
+		tmplCollection.Execute(w, data)
+	}
+
+	r.HandleFunc("/block/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to block or mute"))
+			return
+		}
+
+		target, err := app.getUserByUUID(vars["uuid"])
+		if err != nil || target.UUID == appstate.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user to block"))
+			return
+		}
+
+		kind := r.FormValue("kind")
+		if kind != "mute" {
+			kind = "block"
+		}
+
+		err = app.blockUser(User{UUID: appstate.UUID}, target, kind)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to " + kind + " user"))
+			return
+		}
+
+		http.Redirect(w, r, "/user/" + target.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/unblock/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to unblock"))
+			return
+		}
+
+		err := app.unblockUser(User{UUID: appstate.UUID}, User{UUID: vars["uuid"]})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to unblock user"))
+			return
+		}
+
+		back := "/user/" + vars["uuid"]
+		if r.FormValue("from") == "blocked" {
+			back = "/blocked"
+		}
+		http.Redirect(w, r, back, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/blocked", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		blocks, err := app.getBlocks(User{UUID: appstate.UUID})
+		if err != nil {
+			blocks = make([]Block, 0)
+		}
+
+		type blocked struct {
+			Block Block
+			User User
+		}
+
+		var users []blocked
+		for _, block := range blocks {
+			user, err := app.getUserByUUID(block.BlockedUUID)
+			if err != nil {
+				continue
+			}
+			users = append(users, blocked{Block: block, User: user})
+		}
+
+		data := map[string]interface{}{
+			"Blocked": users,
+			"ApplicationState": appstate,
+		}
+
+		tmplBlocked.Execute(w, data)
+	})
+
+	r.HandleFunc("/bookmark/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to save posts"))
+			return
+		}
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid post to save"))
+			return
+		}
+
+		collectionUUID := r.FormValue("collection")
+		if collectionUUID != "" {
+			collection, err := app.getCollectionByUUID(collectionUUID)
+			if err != nil || collection.UserUUID != appstate.UUID {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Provide one of your collections"))
+				return
+			}
+		}
+
+		err = app.bookmarkPost(post, User{UUID: appstate.UUID}, collectionUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to save post"))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+
+	r.HandleFunc("/unbookmark/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to save posts"))
+			return
+		}
+
+		err := app.removeBookmark(Post{UUID: vars["uuid"]}, User{UUID: appstate.UUID})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to remove bookmark"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/moveBookmark/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to organise bookmarks"))
+			return
+		}
+
+		collectionUUID := r.FormValue("collection")
+		if collectionUUID != "" {
+			collection, err := app.getCollectionByUUID(collectionUUID)
+			if err != nil || collection.UserUUID != appstate.UUID {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Provide one of your collections"))
+				return
+			}
+		}
+
+		err := app.moveBookmark(Post{UUID: vars["uuid"]}, User{UUID: appstate.UUID}, collectionUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to move bookmark"))
+			return
+		}
+
+		back := "/saved"
+		if collectionUUID != "" {
+			back = "/collection/" + collectionUUID
+		}
+		http.Redirect(w, r, back, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/user/{uuid}/collections", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		owner, err := app.getUserByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		isOwner := appstate.SignedIn && appstate.UUID == owner.UUID
+
+		collections, err := app.getCollectionsByUser(owner, isOwner)
+		if err != nil {
+			collections = make([]Collection, 0)
+		}
+
+		data := map[string]interface{}{
+			"User": owner,
+			"Collections": collections,
+			"IsOwner": isOwner,
+			"ApplicationState": appstate,
+		}
+
+		tmplCollections.Execute(w, data)
+	})
+
+	r.HandleFunc("/collections/new", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to make collections"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		collection := Collection{
+			UserUUID: appstate.UUID,
+			Name: strings.TrimSpace(r.FormValue("name")),
+			Public: r.FormValue("public") != "",
+		}
+		if collection.Name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Collections need a name"))
+			return
+		}
+
+		collectionUUID, err := app.createCollection(collection)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to create collection"))
+			return
+		}
+
+		http.Redirect(w, r, "/collection/" + collectionUUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	// unfiled bookmarks, only ever shown to their owner
+	r.HandleFunc("/saved", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to see saved posts"))
+			return
+		}
+
+		renderCollection(w, r, Collection{UserUUID: appstate.UUID, Name: "Saved"}, appstate)
+	})
+
+	r.HandleFunc("/collection/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		collection, err := app.getCollectionByUUID(vars["uuid"])
+		if err != nil || (!collection.Public && collection.UserUUID != appstate.UUID) {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		renderCollection(w, r, collection, appstate)
+	})
+
+	r.HandleFunc("/collection/{uuid}/edit", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		collection, err := app.getCollectionByUUID(vars["uuid"])
+		if err != nil || !appstate.SignedIn || collection.UserUUID != appstate.UUID {
+			app.NotFoundHandler(w, r)
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Collections need a name"))
+			return
+		}
+
+		err = app.editCollection(collection, name, r.FormValue("public") != "")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to edit collection"))
+			return
+		}
+
+		http.Redirect(w, r, "/collection/" + collection.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/collection/{uuid}/delete", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		collection, err := app.getCollectionByUUID(vars["uuid"])
+		if err != nil || !appstate.SignedIn || collection.UserUUID != appstate.UUID {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		err = app.deleteCollection(collection)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete collection"))
+			return
+		}
+
+		http.Redirect(w, r, "/user/" + appstate.UUID + "/collections", http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/follow/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to follow"))
+			return
+		}
+
+		followee, err := app.getUserByUUID(vars["uuid"])
+
+		if err != nil || followee.UUID == appstate.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user to follow"))
+			return
+		}
+
+		err = app.followUser(User{UUID: appstate.UUID}, followee)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to follow user"))
+			return
+		}
+
+		err = app.notify(Notification{
+			UserUUID: followee.UUID,
+			Type: "follow",
+			ActorUUID: appstate.UUID,
+			ActorName: appstate.UserName,
+			TargetUUID: followee.UUID,
+		})
+		if err != nil {
+			fmt.Println("Failed to send follow notification")
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+
+	r.HandleFunc("/unfollow/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to unfollow"))
+			return
+		}
+
+		followee, err := app.getUserByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user to unfollow"))
+			return
+		}
+
+		err = app.unfollowUser(User{UUID: appstate.UUID}, followee)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to unfollow user"))
+			return
+		}
+
+		if err := app.unnotify("follow", appstate.UUID, followee.UUID); err != nil {
+			fmt.Println("Failed to take back follow notification")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/notifications", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		user := User{UUID: appstate.UUID}
+
+		groups, err := app.getNotificationGroups(user, 50, 0)
+		if err != nil {
+			groups = make([]NotificationGroup, 0)
+		}
+
+		for i, group := range groups {
+			if group.PostUUID == "" {
+				continue
+			}
+			post, err := app.getPostByUUID(group.PostUUID)
+			if err == nil {
+				group.PostTitle = post.Title
+			}
+			groups[i] = group
+		}
+
+		preferences, err := app.getNotificationPreferences(user)
+		if err != nil {
+			fmt.Println("Failed to get notification preferences")
+		}
+
+		data := map[string]interface{}{
+			"Groups": groups,
+			"Types": notificationTypes,
+			"Preferences": preferences,
+			"ApplicationState": appstate,
+		}
+
+		tmplNotifications.Execute(w, data)
+	})
+
+	r.HandleFunc("/notifications/read", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to read notifications"))
+			return
+		}
+
+		err := app.markNotificationsRead(User{UUID: appstate.UUID}, r.FormValue("type"), r.FormValue("target"))
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to mark notifications read"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/notifications/preferences", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to change preferences"))
+			return
+		}
+
+		for _, notificationType := range notificationTypes {
+			err := app.setNotificationPreference(User{UUID: appstate.UUID}, notificationType.Type, r.FormValue(notificationType.Type) == "on")
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to save preferences"))
+				return
+			}
+		}
+
+		http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		conversations, err := app.getConversationsByUser(User{UUID: appstate.UUID}, 50, 0)
+		if err != nil {
+			conversations = make([]ConversationSummary, 0)
+		}
+
+		data := map[string]interface{}{
+			"Conversations": conversations,
+			"To": r.URL.Query().Get("to"),
+			"ApplicationState": appstate,
+		}
+
+		tmplInbox.Execute(w, data)
+	})
+
+	r.HandleFunc("/messages/new", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to send messages"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		me := User{UUID: appstate.UUID, Name: appstate.UserName}
+		members := []User{me}
+		seen := map[string]bool{me.UUID: true}
+
+		for _, handle := range strings.Split(r.FormValue("to"), ",") {
+			handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+			if handle == "" {
+				continue
+			}
+
+			user, err := app.getUserByHandle(handle)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("User " + handle + " not found"))
+				return
+			}
+			if blocked, err := app.hasBlocked(user, me); err != nil || blocked {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("You can't message " + handle))
+				return
+			}
+			if !seen[user.UUID] {
+				seen[user.UUID] = true
+				members = append(members, user)
+			}
+		}
+
+		if len(members) < 2 || len(members) > maxConversationMembers {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Message between 1 and " + strconv.Itoa(maxConversationMembers - 1) + " other users"))
+			return
+		}
+
+		title := r.FormValue("title")
+		if len(members) == 2 && title == "" {
+			existing, found, err := app.findDirectConversation(members[0], members[1])
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to start conversation"))
+				return
+			}
+			if found {
+				http.Redirect(w, r, "/messages/" + existing.UUID, http.StatusSeeOther)
+				return
+			}
+		}
+
+		conversation_uuid, err := app.createConversation(title, members)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to start conversation"))
+			return
+		}
+
+		http.Redirect(w, r, "/messages/" + conversation_uuid, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/messages/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		me := User{UUID: appstate.UUID}
+
+		conversation, err := app.getConversation(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		member, err := app.getConversationMember(conversation, me)
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+		messages, err := app.getMessages(conversation, me, 50, before)
+		if err != nil {
+			messages = make([]Message, 0)
+		}
+
+		var older int64
+		if len(messages) == 50 {
+			older = messages[0].Seq
+		}
+
+		members, err := app.getConversationMembers(conversation)
+		if err != nil {
+			members = make([]ConversationMember, 0)
+		}
+
+		if before == 0 {
+			err = app.markConversationRead(conversation, me)
+			if err != nil {
+				fmt.Println("Failed to mark conversation read")
+			}
+		}
+
+		data := map[string]interface{}{
+			"Conversation": conversation,
+			"Members": members,
+			"Muted": member.Muted,
+			"Messages": messages,
+			"Older": older,
+			"ApplicationState": app.genAppState(r),
+		}
+
+		tmplConversation.Execute(w, data)
+	})
+
+	r.HandleFunc("/messages/{uuid}/send", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to send messages"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		conversation, err := app.getConversation(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid conversation"))
+			return
+		}
+
+		if _, err := app.getConversationMember(conversation, User{UUID: appstate.UUID}); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Not a member of this conversation"))
+			return
+		}
+
+		members, err := app.getConversationMembers(conversation)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to send message"))
+			return
+		}
+		for _, member := range members {
+			if blocked, err := app.hasBlocked(User{UUID: member.UserUUID}, User{UUID: appstate.UUID}); err != nil || blocked {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Someone in this conversation has blocked you"))
+				return
+			}
+		}
+
+		content := strings.TrimSpace(r.FormValue("content"))
+		if content == "" {
+			http.Redirect(w, r, "/messages/" + conversation.UUID, http.StatusSeeOther)
+			return
+		}
+
+		_, err = app.sendMessage(Message{
+			ConversationUUID: conversation.UUID,
+			UserUUID: appstate.UUID,
+			UserName: appstate.UserName,
+			Content: content,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to send message"))
+			return
+		}
+
+		http.Redirect(w, r, "/messages/" + conversation.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/messages/{uuid}/mute", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to mute conversations"))
+			return
+		}
+
+		conversation, err := app.getConversation(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid conversation"))
+			return
+		}
+
+		err = app.setConversationMuted(conversation, User{UUID: appstate.UUID}, r.FormValue("muted") == "true")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to mute conversation"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/report/{type}/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		group := ReportGroup{TargetType: vars["type"], TargetUUID: vars["uuid"]}
+		if err := app.loadReportTarget(&group); err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if group.AuthorUUID() == appstate.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("You can't report yourself"))
+			return
+		}
+
+		sent := false
+		if r.Method == "POST" {
+			category := r.FormValue("category")
+			if reportCategoryLabel(category) == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Pick a reason for the report"))
+				return
+			}
+
+			_, err := app.report(Report{
+				TargetType: group.TargetType,
+				TargetUUID: group.TargetUUID,
+				ReporterUUID: appstate.UUID,
+				Category: category,
+				Details: strings.TrimSpace(r.FormValue("details")),
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to send report"))
+				return
+			}
+
+			sent = true
+		}
+
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Target": group,
+			"Categories": reportCategories,
+			"Sent": sent,
+		}
+
+		tmplReport.Execute(w, data)
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/reportMessage/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to report messages"))
+			return
+		}
+
+		message, err := app.getMessageByUUID(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid message to report"))
+			return
+		}
+
+		// only members can see a message, so only members can report it
+		conversation := Conversation{UUID: message.ConversationUUID}
+		if _, err := app.getConversationMember(conversation, User{UUID: appstate.UUID}); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Not a member of this conversation"))
+			return
+		}
+
+		_, err = app.reportMessage(MessageReport{
+			MessageUUID: message.UUID,
+			ReporterUUID: appstate.UUID,
+			Reason: r.FormValue("reason"),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to report message"))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+
+	r.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{
+			"ApplicationState": app.genAppState(r),
+		}
+		tmplLogin.Execute(w, data)
+	})
+
+	r.HandleFunc("/loginSubmit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		handle := r.FormValue("handle")
+		password := r.FormValue("password")
+
+		user, err := app.getUserByHandle(handle)
+		if err != nil {
+			// signing back in within the window undoes deleting your own account
+			user, err = app.getDeletedUser(handle)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("User " + handle + " not found"))
+				return
+			}
+			if user.DeletedBy != user.UUID || time.Since(user.DeletedAt) > *retention {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("This account has been deleted"))
+				return
+			}
+		}
+
+		cookie, err := app.signIn(user.UUID, password)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Invalid credentials"))
+			return
+		}
+
+		// only someone with the password learns the account is banned and why
+		if ban, err := app.getActiveBan(user); err == nil && ban.UUID != "" {
+			app.logOut(cookie)
+
+			message := "This account is banned"
+			if !ban.Permanent {
+				message += " until " + ban.ExpiresAt.Format("Jan 2, 2006 15:04")
+			}
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(message + ": " + ban.Reason))
+			return
+		}
+
+		if user.Deleted {
+			err = app.restoreUser(user)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to restore account"))
+				return
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name: "auth",
+			Value: cookie,
+		})
+		
+		http.Redirect(w, r, "/user/" + user.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/handleExists/{handle}", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if vars["handle"] == "" {
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("invalid handle"))
 			return
 		}
 
-		_, err := app.getUserByHandle(vars["handle"])
+		taken, err := app.handleTaken(vars["handle"])
+
+		if err == nil && !taken {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("false"))
+		} else if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("error"))
+		} else {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("true"))
+		}
+	})
+
+	r.HandleFunc("/signup", func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{
+			"ApplicationState": app.genAppState(r),
+		}
+		tmplSignUp.Execute(w, data)
+	})
+
+	r.HandleFunc("/signupForm", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		handle := r.FormValue("handle")
+		name := r.FormValue("name")
+		email := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+		password := r.FormValue("password")
+		bio := r.FormValue("bio")
+
+		if handle == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing handle"))
+			return
+		}
+
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing name"))
+			return
+		}
+
+		if password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing password"))
+			return
+		}
+
+		if email == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing email"))
+			return
+		}
+
+		if banned, err := app.emailBanned(email); err != nil || banned {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("This email can't be used to sign up"))
+			return
+		}
+
+		user := User {
+			Name: name,
+			Handle: handle,
+		}
+
+		if bio != "" {
+			user.Bio = bio
+		}
+
+		uuid, err := app.createUser(user, email, password)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("something went wrong"))
+			return
+		}
+
+		cookie, err := app.signIn(uuid, password)
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("something went wrong"))
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name: "auth",
+			Value: cookie,
+		})
+		
+		http.Redirect(w, r, "/user/" + uuid, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/submitComment", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to comment"))
+			return
+		}
+
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		var comment Comment
+
+		comment.Content = r.FormValue("content")
+		comment.PostUUID = r.FormValue("post")
+		comment.ParentUUID = r.FormValue("parent")
+		comment.UserUUID = appstate.UUID
+		comment.UserName = appstate.UserName
+
+		post, err := app.getPostByUUID(comment.PostUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid post to comment on"))
+			return
+		}
+
+		var parent Comment
+		if comment.ParentUUID != "" {
+			parent, err = app.getCommentByUUID(comment.ParentUUID)
+			if err != nil || parent.PostUUID != comment.PostUUID || parent.Deleted {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Provide a valid comment to reply to"))
+				return
+			}
+		}
+
+		blocked, err := app.hasBlocked(User{UUID: post.UserUUID}, User{UUID: comment.UserUUID})
+		if err == nil && !blocked && parent.UUID != "" {
+			blocked, err = app.hasBlocked(User{UUID: parent.UserUUID}, User{UUID: comment.UserUUID})
+		}
+		if err != nil || blocked {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("You can't comment here"))
+			return
+		}
+
+		comment.Timestamp, comment.Timed, err = parseTimestamp(r.FormValue("timestamp"))
+		if err == nil && comment.Timed && !post.IsVideo() {
+			err = errors.New("only video posts take timestamps")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		region, hasRegion, err := parseRegion(r.FormValue("region"))
+		if err == nil && hasRegion && !comment.Timed {
+			err = errors.New("a region needs a timestamp to go with it")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		comment.RegionX, comment.RegionY, comment.RegionW, comment.RegionH = region[0], region[1], region[2], region[3]
+
+		author, err := app.getUserByUUID(appstate.UUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to create comment"))
+			return
+		}
+
+		action, matched := app.automod("comment", author, comment.Content)
+		if action == "reject" {
+			app.logAutomod(matched, "comment", "", author, comment.Content)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Your comment was blocked by an automatic filter"))
+			return
+		}
+		comment.Automod = automodWithheld[action]
+
+		comment.UUID, err = app.createComment(comment)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to create comment"))
+			return
+		}
+
+		app.logAutomod(matched, "comment", comment.UUID, author, comment.Content)
+
+		// withheld comments are announced if a moderator publishes them
+		if comment.Automod == "" {
+			app.announceComment(comment, post, parent)
+		}
+
+		http.Redirect(w, r, "/post/" + comment.PostUUID, http.StatusSeeOther)	
+	})
+
+	r.HandleFunc("/acceptAnswer/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to accept answers"))
+			return
+		}
+
+		comment, err := app.getCommentByUUID(vars["uuid"])
+		if err != nil || comment.Deleted {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid comment to accept"))
+			return
+		}
+
+		post, err := app.getPostByUUID(comment.PostUUID)
+		if err != nil || !post.FormCheck {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Only form checks take accepted answers"))
+			return
+		}
+
+		if post.UserUUID != appstate.UUID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only the author can accept an answer"))
+			return
+		}
+
+		// accepting the current answer again takes it back
+		if post.AcceptedUUID == comment.UUID {
+			err = app.setAcceptedAnswer(post, "")
+			if err == nil {
+				err = app.unnotify("accepted", appstate.UUID, comment.UUID)
+			}
+		} else {
+			err = app.setAcceptedAnswer(post, comment.UUID)
+			if err == nil && post.AcceptedUUID != "" {
+				err = app.unnotify("accepted", appstate.UUID, post.AcceptedUUID)
+			}
+			if err == nil {
+				err = app.notify(Notification{
+					UserUUID: comment.UserUUID,
+					Type: "accepted",
+					ActorUUID: appstate.UUID,
+					ActorName: appstate.UserName,
+					TargetUUID: comment.UUID,
+					PostUUID: post.UUID,
+				})
+			}
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to accept answer"))
+			return
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID + "#" + comment.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/requestVerification/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.SignedIn || post.UserUUID != appstate.UUID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only the lifter can ask for verification"))
+			return
+		}
+
+		err = app.requestVerification(post)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to request verification"))
+			return
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/verifyPost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to verify lifts"))
+			return
+		}
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		user, err := app.getUserByUUID(appstate.UUID)
+		if err != nil || !app.canVerify(post, user) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only verifiers and the lifter's gym mates can vote on this lift"))
+			return
+		}
+
+		err = app.voteVerification(post, user, r.FormValue("legit") != "", r.FormValue("standard") != "")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to record vote"))
+			return
+		}
+
+		status, err := app.tallyVerification(post)
+		if err != nil {
+			fmt.Println("Failed to tally verification votes")
+		}
+		if status == "verified" && post.Verification != "verified" {
+			err = app.notify(Notification{
+				UserUUID: post.UserUUID,
+				Type: "verified",
+				ActorUUID: user.UUID,
+				ActorName: user.Name,
+				TargetUUID: post.UUID,
+				PostUUID: post.UUID,
+			})
+			if err != nil {
+				fmt.Println("Failed to send verified notification")
+			}
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/requestJudging/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if !appstate.SignedIn || post.UserUUID != appstate.UUID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Only the lifter can submit a lift for judging"))
+			return
+		}
+
+		// judges asked for by handle, any seats left over are open to volunteers
+		var judges []User
+		for _, handle := range strings.FieldsFunc(r.FormValue("judges"), func(c rune) bool { return c == ',' || c == ' ' }) {
+			judge, err := app.getUserByHandle(strings.TrimPrefix(handle, "@"))
+			if err != nil || judge.UUID == post.UserUUID {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Can't seat @" + strings.TrimPrefix(handle, "@") + " as a judge"))
+				return
+			}
+			judges = append(judges, judge)
+		}
+		if len(judges) > judgingSeats {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("A panel only has " + strconv.Itoa(judgingSeats) + " seats"))
+			return
+		}
+
+		err = app.requestJudging(post)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to submit for judging"))
+			return
+		}
+
+		for _, judge := range judges {
+			err = app.takeJudgingSeat(post, judge)
+			if err != nil {
+				continue
+			}
+			err = app.notify(Notification{
+				UserUUID: judge.UUID,
+				Type: "judge",
+				ActorUUID: appstate.UUID,
+				ActorName: appstate.UserName,
+				TargetUUID: post.UUID,
+				PostUUID: post.UUID,
+			})
+			if err != nil {
+				fmt.Println("Failed to send judge notification")
+			}
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	// sit takes a free seat, leave gives one up before voting, light records the judge's decision
+	r.HandleFunc("/judge/{uuid}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to judge lifts"))
+			return
+		}
+
+		post, err := app.getPostByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if post.Judging != "open" || post.UserUUID == appstate.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("This lift isn't open for you to judge"))
+			return
+		}
+
+		judge := User{UUID: appstate.UUID, Name: appstate.UserName}
+
+		switch vars["action"] {
+		case "sit":
+			err = app.takeJudgingSeat(post, judge)
+			if err == errSeatsFull {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		case "leave":
+			err = app.leaveJudgingSeat(post, judge)
+		case "light":
+			r.ParseForm()
+			faults, parseErr := parseLight(r.FormValue("light"), r.Form["fault"])
+			if parseErr != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(parseErr.Error()))
+				return
+			}
+			err = app.submitLight(post, judge, r.FormValue("light"), faults)
+		default:
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to update judging"))
+			return
+		}
+
+		judgements, err := app.getJudgements(post)
+		if err != nil {
+			fmt.Println("Failed to get judgements")
+		}
+
+		if result := judgingResult(judgements); result != "" {
+			published, err := app.publishJudging(post, result)
+			if err != nil {
+				fmt.Println("Failed to publish judging result")
+			}
+			if published {
+				err = app.notify(Notification{
+					UserUUID: post.UserUUID,
+					Type: "judged",
+					ActorUUID: appstate.UUID,
+					ActorName: appstate.UserName,
+					TargetUUID: post.UUID,
+					PostUUID: post.UUID,
+				})
+				if err != nil {
+					fmt.Println("Failed to send judged notification")
+				}
+			}
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/editPost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to edit posts"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		post, err := app.getPostByUUID(vars["uuid"])
+
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		if post.UserUUID != appstate.UUID && !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be owner to edit"))
+			return
+		}
+
+		// the gym list is the author's, a moderator editing keeps the author's choices
+		gyms, err := app.getGymsByUser(User{UUID: post.UserUUID})
+		if err != nil {
+			gyms = make([]Gym, 0)
+		}
+
+		if r.Method != "POST" {
+			data := map[string]interface{}{
+				"Post": post,
+				"Gyms": gyms,
+				"ApplicationState": appstate,
+			}
+
+			tmplEdit.Execute(w, data)
+			return
+		}
+
+		edited := post
+		edited.Title = r.FormValue("title")
+		edited.Description = r.FormValue("description")
+		edited.Weight, _ = strconv.Atoi(r.FormValue("weight"))
+		edited.Lift = r.FormValue("lift")
+		edited.Reps, _ = strconv.Atoi(r.FormValue("reps"))
+		edited.Sets, _ = strconv.Atoi(r.FormValue("sets"))
+		edited.FormCheck = r.FormValue("formcheck") != ""
+		edited.GymUUID, edited.GymName = "", ""
+		for _, gym := range gyms {
+			if gym.UUID == r.FormValue("gym") {
+				edited.GymUUID, edited.GymName = gym.UUID, gym.Name
+			}
+		}
+
+		// the rules judge the author, whoever is editing
+		author, err := app.getUserByUUID(post.UserUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to edit post"))
+			return
+		}
+
+		action, matched := app.automod("post", author, edited.Title + "\n" + edited.Description)
+		if action == "reject" {
+			app.logAutomod(matched, "post", "", author, edited.Title + "\n" + edited.Description)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Your edit was blocked by an automatic filter"))
+			return
+		}
+		// something already withheld stays that way until a moderator publishes it
+		if edited.Automod == "" {
+			edited.Automod = automodWithheld[action]
+		}
+
+		err = app.editPost(post, edited, User{UUID: appstate.UUID})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to edit post"))
+			return
+		}
+
+		app.logAutomod(matched, "post", post.UUID, author, edited.Title + "\n" + edited.Description)
+
+		if edited.Automod == "" {
+			err = app.recordMarkup(post.UUID, "post", post.UUID, edited.Description, User{UUID: appstate.UUID, Name: appstate.UserName})
+			if err != nil {
+				fmt.Println("Failed to record mentions and tags")
+			}
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID, http.StatusSeeOther)
+	}).Methods("GET", "POST")
+
+	r.HandleFunc("/editComment/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to edit comments"))
+			return
+		}
+		if app.refuseSuspended(w, User{UUID: appstate.UUID}) {
+			return
+		}
+
+		comment, err := app.getCommentByUUID(vars["uuid"])
+
+		if err != nil || comment.Deleted {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid comment to edit"))
+			return
+		}
+
+		if comment.UserUUID != appstate.UUID && !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be owner to edit"))
+			return
+		}
+
+		// the rules judge the author, whoever is editing
+		author, err := app.getUserByUUID(comment.UserUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to edit comment"))
+			return
+		}
+
+		content := r.FormValue("content")
+		action, matched := app.automod("comment", author, content)
+		if action == "reject" {
+			app.logAutomod(matched, "comment", "", author, content)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Your edit was blocked by an automatic filter"))
+			return
+		}
+		// something already withheld stays that way until a moderator publishes it
+		withheld := comment.Automod
+		if withheld == "" {
+			withheld = automodWithheld[action]
+		}
+
+		err = app.editComment(comment, content, withheld, User{UUID: appstate.UUID})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to edit comment"))
+			return
+		}
+
+		app.logAutomod(matched, "comment", comment.UUID, author, content)
+
+		if withheld == "" {
+			err = app.recordMarkup(comment.UUID, "comment", comment.PostUUID, content, User{UUID: appstate.UUID, Name: appstate.UserName})
+			if err != nil {
+				fmt.Println("Failed to record mentions and tags")
+			}
+		}
+
+		http.Redirect(w, r, "/post/" + comment.PostUUID + "#" + comment.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/history/{type}/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		var current Revision
+		var postUUID string
+
+		switch vars["type"] {
+		case "post":
+			post, err := app.getPostByUUID(vars["uuid"])
+			if err != nil {
+				app.NotFoundHandler(w, r)
+				return
+			}
+			current = postRevision(post)
+			postUUID = post.UUID
+		case "comment":
+			comment, err := app.getCommentByUUID(vars["uuid"])
+			if err != nil {
+				app.NotFoundHandler(w, r)
+				return
+			}
+			current = commentRevision(comment)
+			postUUID = comment.PostUUID
+		default:
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		revisions, err := app.getRevisions(vars["uuid"])
+		if err != nil {
+			revisions = make([]Revision, 0)
+		}
+
+		data := map[string]interface{}{
+			"Type": vars["type"],
+			"PostUUID": postUUID,
+			"Original": append(revisions, current)[0],
+			"History": revisionHistory(revisions, current),
+			"ApplicationState": appstate,
+		}
+
+		tmplHistory.Execute(w, data)
+	})
+
+	r.HandleFunc("/submitPost", func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+		cookie, err := r.Cookie("auth")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to post"))
+			return
+		}
+		user, err := app.validateCookie(cookie.Value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid cookie"))
+			return
+		}
+
+		if app.refuseSuspended(w, user) {
+			return
+		}
+
+		if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("Upload must be a form under " + strconv.Itoa(maxUploadSize >> 20) + " MB"))
+			return
+		}
+
+		var post Post
+
+		post.Title = r.FormValue("title")
+		post.Description = r.FormValue("description")
+
+		action, matched := app.automod("post", user, post.Title + "\n" + post.Description)
+		if action == "reject" {
+			app.logAutomod(matched, "post", "", user, post.Title + "\n" + post.Description)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Your post was blocked by an automatic filter"))
+			return
+		}
+		post.Automod = automodWithheld[action]
+
+		temp_weight, _ := strconv.Atoi(r.FormValue("weight"))
+		post.Weight = int(temp_weight)
+		post.Lift= r.FormValue("lift")
+		post.Reps, _ = strconv.Atoi(r.FormValue("reps"))
+		post.Sets, _ = strconv.Atoi(r.FormValue("sets"))
+		post.FormCheck = r.FormValue("formcheck") != ""
+
+		if gymUUID := r.FormValue("gym"); gymUUID != "" {
+			gym, err := app.getGymByUUID(gymUUID)
+			if err == nil {
+				member, err := app.getGymMember(gym, user)
+				if err == nil && member.Approved {
+					post.GymUUID, post.GymName = gym.UUID, gym.Name
+				}
+			}
+			if post.GymUUID == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Only members can tag a gym"))
+				return
+			}
+		}
+
+		post.UserUUID = user.UUID
+		post.UserName = user.Name
+		
+		file, _, err := r.FormFile("thumbnail")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Insert an image"))
+			return
+		}
+		defer file.Close()
+
+		data, mediaType, err := readUpload(file)
+		if err != nil {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			w.Write([]byte("Upload a JPEG, PNG, GIF, MP4 or WebM file"))
+			return
+		}
+		post.MediaType = mediaType
+
+		// both hashes come from the bytes so banned media is turned away before it touches the store
+		post.MediaHash = blobHash(data)
+		post.PerceptualHash = perceptualHash(data, mediaType)
+
+		banned, err := app.isMediaBanned(post.MediaHash, post.PerceptualHash)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to submit post due to internal error"))
+			return
+		}
+		if banned {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("This media has been banned by a moderator"))
+			return
+		}
+
+		// blob goes to disk before the post exists so a failed save can't leave a post with no media,
+		// the lock keeps the GC from collecting a duplicate we're about to reference
+		mediaLock.Lock()
+
+		_, err = storeBlob(data)
+		if err != nil {
+			mediaLock.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to save upload"))
+			return
+		}
+
+		post_uuid, err := app.createPost(post)
+		if err != nil {
+			mediaLock.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to submit post due to internal error"))
+			return
+		}
+		post.UUID = post_uuid
+
+		// a -gc run in another process may have collected the blob before our reference landed
+		_, err = storeBlob(data)
+		mediaLock.Unlock()
+		if err != nil {
+			fmt.Println("Failed to restore collected media for", post.UUID, err)
+		}
+
+		app.logAutomod(matched, "post", post.UUID, user, post.Title + "\n" + post.Description)
+
+		// withheld posts get their tags and mentions if a moderator publishes them
+		if post.Automod == "" {
+			err = app.recordMarkup(post.UUID, "post", post.UUID, post.Description, user)
+			if err != nil {
+				fmt.Println("Failed to record mentions and tags")
+			}
+		}
+
+		match, distance, found, err := app.findDuplicate(post)
+		if err != nil {
+			fmt.Println("Failed to check for duplicate media")
+		} else if found {
+			_, err = app.createDuplicateFlag(DuplicateFlag{PostUUID: post.UUID, MatchUUID: match.UUID, Distance: distance})
+			if err != nil {
+				fmt.Println("Failed to flag duplicate media")
+			}
+		}
+
+		http.Redirect(w, r, "/post/" + post_uuid, http.StatusSeeOther)
+
+	})
+
+	r.HandleFunc("/deleteUser/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Sign in to delete users")))
+			return
+		}
+
+		user, err := app.getUserByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user to delete"))
+			return
+		}
+
+		if user.UUID != appstate.UUID && !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be owner to delete"))
+			return
+		}
+
+		err = app.deleteUser(user, User{UUID: appstate.UUID})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete post"))
+			return
+		}
+
+		if user.UUID != appstate.UUID {
+			app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "deleteUser", "user", user.UUID, r.FormValue("reason"), user)
+		}
+		app.closeDeletedReports(ReportGroup{TargetType: "user", TargetUUID: user.UUID, User: user}, User{UUID: appstate.UUID})
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/deletePost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Sign in to delete posts")))
+			return
+		}
+
+		post, err := app.getPostByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid post to delete"))
+			return
+		}
+
+		if post.UserUUID != appstate.UUID && !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be owner to delete"))
+			return
+		}
+
+		err = app.deletePost(post, User{UUID: appstate.UUID})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete post"))
+			return
+		}
+
+		if post.UserUUID != appstate.UUID {
+			app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "deletePost", "post", post.UUID, r.FormValue("reason"), post)
+		}
+		app.closeDeletedReports(ReportGroup{TargetType: "post", TargetUUID: post.UUID, Post: post}, User{UUID: appstate.UUID})
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/deleteComment/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(("Sign in to delete comments")))
+			return
+		}
+
+		comment, err := app.getCommentByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid comment to delete"))
+			return
+		}
+
+		if comment.UserUUID != appstate.UUID && !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be owner to delete"))
+			return
+		}
+
+		err = app.deleteComment(comment, User{UUID: appstate.UUID})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete comment"))
+			return
+		}
+
+		if comment.UserUUID != appstate.UUID {
+			app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "deleteComment", "comment", comment.UUID, r.FormValue("reason"), comment)
+		}
+		app.closeDeletedReports(ReportGroup{TargetType: "comment", TargetUUID: comment.UUID, Comment: comment}, User{UUID: appstate.UUID})
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/suspend/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to suspend users"))
+			return
+		}
+
+		user, err := app.getUserByUUID(vars["uuid"])
+		if err != nil || user.UUID == appstate.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user to suspend"))
+			return
+		}
+
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if reason == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Give a reason"))
+			return
+		}
+
+		suspension := Suspension{
+			UserUUID: user.UUID,
+			ModeratorUUID: appstate.UUID,
+			Kind: "suspension",
+			Reason: reason,
+		}
+		if r.FormValue("kind") == "ban" {
+			suspension.Kind = "ban"
+			suspension.Permanent = r.FormValue("permanent") != ""
+		}
+
+		if !suspension.Permanent {
+			days, err := strconv.Atoi(r.FormValue("days"))
+			if err != nil || days < 1 || days > maxSuspensionDays {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Suspensions and temporary bans run from 1 to " + strconv.Itoa(maxSuspensionDays) + " days"))
+				return
+			}
+			suspension.ExpiresAt = time.Now().AddDate(0, 0, days)
+		}
+
+		_, err = app.suspendUser(suspension)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to " + suspension.Kind + " user"))
+			return
+		}
+
+		action := "suspendUser"
+		if suspension.Kind == "ban" {
+			action = "banUser"
+		} else {
+			err = app.notify(Notification{
+				UserUUID: user.UUID,
+				Type: "suspended",
+				ActorUUID: moderators.UUID,
+				ActorName: moderators.Name,
+				TargetUUID: user.UUID,
+				Note: "until " + suspension.ExpiresAt.Format("Jan 2, 2006 15:04") + ": " + reason,
+			})
+			if err != nil {
+				fmt.Println("Failed to notify suspended user")
+			}
+		}
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, action, "user", user.UUID, reason, user)
+
+		http.Redirect(w, r, "/user/" + user.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/liftSuspension/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to lift suspensions"))
+			return
+		}
+
+		suspension, err := app.getSuspensionByUUID(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid suspension to lift"))
+			return
+		}
+
+		err = app.liftSuspension(suspension.UUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to lift " + suspension.Kind))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "liftSuspension", "user", suspension.UserUUID, r.FormValue("reason"), suspension)
+
+		http.Redirect(w, r, "/user/" + suspension.UserUUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/restorePost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		post, err := app.getDeletedPost(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a deleted post to restore"))
+			return
+		}
+
+		if !canRestore(post.UserUUID, post.DeletedBy, post.DeletedAt, appstate, *retention) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("You can't restore this post"))
+			return
+		}
+
+		err = app.restorePost(post)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to restore post"))
+			return
+		}
+
+		if post.UserUUID != appstate.UUID {
+			app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "restorePost", "post", post.UUID, r.FormValue("reason"), post)
+		}
+
+		http.Redirect(w, r, "/post/" + post.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/restoreComment/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		comment, err := app.getCommentByUUID(vars["uuid"])
+		if err != nil || !comment.Deleted || comment.Content == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a deleted comment to restore"))
+			return
+		}
+
+		if !canRestore(comment.UserUUID, comment.DeletedBy, comment.DeletedAt, appstate, *retention) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("You can't restore this comment"))
+			return
+		}
+
+		err = app.restoreComment(comment)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to restore comment"))
+			return
+		}
+
+		if comment.UserUUID != appstate.UUID {
+			app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "restoreComment", "comment", comment.UUID, r.FormValue("reason"), comment)
+		}
+
+		http.Redirect(w, r, "/post/" + comment.PostUUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	// people restore their own account by signing back in, this is for moderators
+	r.HandleFunc("/restoreUser/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		user, err := app.getDeletedUser(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a deleted user to restore"))
+			return
+		}
+
+		if !appstate.Moderator || !canRestore(user.UUID, user.DeletedBy, user.DeletedAt, appstate, *retention) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("You can't restore this user"))
+			return
+		}
+
+		err = app.restoreUser(user)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to restore user"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "restoreUser", "user", user.UUID, r.FormValue("reason"), user)
+
+		http.Redirect(w, r, "/user/" + user.UUID, http.StatusSeeOther)
+	}).Methods("POST")
+
+	// renders the trash for one user, or everyone's when user is empty
+	renderTrash := func(w http.ResponseWriter, appstate ApplicationState, user User) {
+		posts, err := app.getDeletedPosts(user, 50, 0)
+		if err != nil {
+			posts = make([]Post, 0)
+		}
+
+		comments, err := app.getDeletedComments(user, 50, 0)
+		if err != nil {
+			comments = make([]Comment, 0)
+		}
+
+		var users []User
+		if user.UUID == "" {
+			users, err = app.getDeletedUsers(50, 0)
+			if err != nil {
+				users = make([]User, 0)
+			}
+		}
+
+		type trashed struct {
+			Kind string
+			UUID string
+			Label string
+			Link string
+			Author string
+			ByOwner bool
+			DeletedAt time.Time
+			RestoreBy time.Time
+			CanRestore bool
+		}
+
+		var items []trashed
+		for _, post := range posts {
+			items = append(items, trashed{"post", post.UUID, post.Title, "/post/" + post.UUID, post.UserName,
+				post.DeletedBy == post.UserUUID, post.DeletedAt, restoreDeadline(post.DeletedAt, *retention),
+				canRestore(post.UserUUID, post.DeletedBy, post.DeletedAt, appstate, *retention)})
+		}
+		for _, comment := range comments {
+			items = append(items, trashed{"comment", comment.UUID, comment.Content, "/post/" + comment.PostUUID, comment.UserName,
+				comment.DeletedBy == comment.UserUUID, comment.DeletedAt, restoreDeadline(comment.DeletedAt, *retention),
+				canRestore(comment.UserUUID, comment.DeletedBy, comment.DeletedAt, appstate, *retention)})
+		}
+		for _, deleted := range users {
+			items = append(items, trashed{"user", deleted.UUID, deleted.Name + " (@" + deleted.Handle + ")", "", deleted.Name,
+				deleted.DeletedBy == deleted.UUID, deleted.DeletedAt, restoreDeadline(deleted.DeletedAt, *retention),
+				canRestore(deleted.UUID, deleted.DeletedBy, deleted.DeletedAt, appstate, *retention)})
+		}
+
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].DeletedAt.After(items[j].DeletedAt)
+		})
+
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Items": items,
+			"Everyone": user.UUID == "",
+			"Retention": int(retention.Hours() / 24),
+		}
+
+		tmplTrash.Execute(w, data)
+	}
+
+	r.HandleFunc("/trash", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		renderTrash(w, appstate, User{UUID: appstate.UUID})
+	})
+
+	r.HandleFunc("/admin/trash", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		renderTrash(w, appstate, User{})
+	})
+
+	r.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		search := parseAdminSearch(r.URL.Query())
+
+		// only the table being looked at is loaded
+		var rows int
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Search": search,
+			"Tables": adminTables,
+			"Statuses": adminStatuses[search.Table],
+		}
+
+		switch search.Table {
+		case "users":
+			users, err := app.searchUsers(search)
+			if err != nil {
+				users = make([]User, 0)
+			}
+			data["Users"], rows = users, len(users)
+		case "posts":
+			posts, err := app.searchPosts(search)
+			if err != nil {
+				posts = make([]Post, 0)
+			}
+			data["Posts"], rows = posts, len(posts)
+		case "comments":
+			comments, err := app.searchComments(search)
+			if err != nil {
+				comments = make([]Comment, 0)
+			}
+			data["Comments"], rows = comments, len(comments)
+		case "reports":
+			reports, err := app.searchReports(search)
+			if err != nil {
+				reports = make([]Report, 0)
+			}
+			data["Reports"], rows = reports, len(reports)
+		}
+
+		data["NextPage"] = ""
+		if rows == adminPageSize {
+			data["NextPage"] = search.PageURL(search.Page + 1)
+		}
+		data["PreviousPage"] = ""
+		if search.Page > 0 {
+			data["PreviousPage"] = search.PageURL(search.Page - 1)
+		}
+
+		tmplAdmin.Execute(w, data)
+	})
+
+	r.HandleFunc("/admin/bulk", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to use the admin console"))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid form"))
+			return
+		}
+
+		moderator := User{UUID: appstate.UUID, Name: appstate.UserName}
+		table, action, reason := r.FormValue("table"), r.FormValue("action"), r.FormValue("reason")
+		if action != "delete" && action != "restore" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Select delete or restore"))
+			return
+		}
+
+		// rows that are already the way they were asked to be, or gone, are skipped
+		failed, expired := 0, 0
+		for _, id := range r.Form["uuid"] {
+			var err error
+
+			switch table {
+			case "users":
+				var user User
+				user, err = app.getAnyUserByUUID(id)
+				if err != nil || user.UUID == appstate.UUID || user.Deleted == (action == "delete") {
+					continue
+				}
+				if action == "delete" {
+					err = app.deleteUser(user, moderator)
+					if err == nil {
+						app.closeDeletedReports(ReportGroup{TargetType: "user", TargetUUID: user.UUID, User: user}, moderator)
+					}
+				} else if canRestore(user.UUID, user.DeletedBy, user.DeletedAt, appstate, *retention) {
+					err = app.restoreUser(user)
+				} else {
+					expired++
+					continue
+				}
+				if err == nil {
+					app.audit(moderator, action + "User", "user", user.UUID, reason, user)
+				}
+			case "posts":
+				var post Post
+				post, err = app.getPostByUUID(id)
+				if err != nil {
+					post, err = app.getDeletedPost(id)
+				}
+				if err != nil || post.Deleted == (action == "delete") {
+					continue
+				}
+				if action == "delete" {
+					err = app.deletePost(post, moderator)
+					if err == nil {
+						app.closeDeletedReports(ReportGroup{TargetType: "post", TargetUUID: post.UUID, Post: post}, moderator)
+					}
+				} else if canRestore(post.UserUUID, post.DeletedBy, post.DeletedAt, appstate, *retention) {
+					err = app.restorePost(post)
+				} else {
+					expired++
+					continue
+				}
+				if err == nil {
+					app.audit(moderator, action + "Post", "post", post.UUID, reason, post)
+				}
+			case "comments":
+				var comment Comment
+				comment, err = app.getCommentByUUID(id)
+				if err != nil || comment.Deleted == (action == "delete") {
+					continue
+				}
+				if action == "delete" {
+					err = app.deleteComment(comment, moderator)
+					if err == nil {
+						app.closeDeletedReports(ReportGroup{TargetType: "comment", TargetUUID: comment.UUID, Comment: comment}, moderator)
+					}
+				} else if comment.Content != "" && canRestore(comment.UserUUID, comment.DeletedBy, comment.DeletedAt, appstate, *retention) {
+					// an empty comment is the placeholder left when one with replies is purged
+					err = app.restoreComment(comment)
+				} else {
+					expired++
+					continue
+				}
+				if err == nil {
+					app.audit(moderator, action + "Comment", "comment", comment.UUID, reason, comment)
+				}
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Only users, posts and comments can be deleted in bulk"))
+				return
+			}
+
+			if err != nil {
+				fmt.Println("Bulk", action, "failed on", id, err)
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to " + action + " " + strconv.Itoa(failed) + " of them"))
+			return
+		}
+		if expired > 0 {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(strconv.Itoa(expired) + " of them can no longer be restored"))
+			return
+		}
+
+		back := r.FormValue("back")
+		if !strings.HasPrefix(back, "/admin") {
+			back = "/admin"
+		}
+
+		http.Redirect(w, r, back, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/admin/user/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		user, err := app.getAnyUserByUUID(vars["uuid"])
+		if err != nil {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		email, err := app.getEmail(user)
+		if err != nil {
+			email = ""
+		}
+
+		// first page of each table, the console has the rest
+		search := AdminSearch{User: user.UUID, Sort: "created", Desc: true}
+
+		search.Table = "posts"
+		posts, err := app.searchPosts(search)
+		if err != nil {
+			posts = make([]Post, 0)
+		}
+
+		search.Table = "comments"
+		comments, err := app.searchComments(search)
+		if err != nil {
+			comments = make([]Comment, 0)
+		}
+
+		search.Table = "reports"
+		reports, err := app.searchReports(search)
+		if err != nil {
+			reports = make([]Report, 0)
+		}
+
+		reactions, err := app.getReactionsByUser(user, adminPageSize, 0)
+		if err != nil {
+			reactions = make([]Like, 0)
+		}
+
+		suspensions, err := app.getSuspensions(user)
+		if err != nil {
+			suspensions = make([]Suspension, 0)
+		}
+
+		hits, err := app.getAutomodHitsByUser(user, adminPageSize, 0)
+		if err != nil {
+			hits = make([]AutomodHit, 0)
+		}
+
+		// what moderators did to them and, for moderators, what they did
+		entries, err := app.searchAuditLog(user.UUID, "", adminPageSize, 0)
+		if err != nil {
+			entries = make([]AuditEntry, 0)
+		}
+
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"User": user,
+			"Email": email,
+			"Search": search,
+			"Posts": posts,
+			"Comments": comments,
+			"Reports": reports,
+			"Reactions": reactions,
+			"Suspensions": suspensions,
+			"Hits": hits,
+			"Entries": entries,
+			"PageSize": adminPageSize,
+			"Now": time.Now(),
+		}
+
+		tmplAdminUser.Execute(w, data)
+	})
+
+	r.HandleFunc("/admin/media", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		flags, err := app.getDuplicateFlags(20, 0)
+		if err != nil {
+			flags = make([]DuplicateFlag, 0)
+		}
+
+		type duplicate struct {
+			Flag DuplicateFlag
+			Post Post
+			Match Post
+		}
+
+		duplicates := make([]duplicate, 0, len(flags))
+		for _, flag := range flags {
+			post, err := app.getPostByUUID(flag.PostUUID)
+			if err != nil {
+				continue
+			}
+			match, err := app.getPostByUUID(flag.MatchUUID)
+			if err != nil {
+				continue
+			}
+			duplicates = append(duplicates, duplicate{Flag: flag, Post: post, Match: match})
+		}
+
+		bans, err := app.getBannedMedia(50, 0)
+		if err != nil {
+			bans = make([]BannedMedia, 0)
+		}
+
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Duplicates": duplicates,
+			"Bans": bans,
+		}
+
+		tmplAdminMedia.Execute(w, data)
+	})
+
+	r.HandleFunc("/resolveDuplicate/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to resolve flags"))
+			return
+		}
+
+		err := app.resolveDuplicateFlag(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to resolve flag"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "resolveDuplicate", "flag", vars["uuid"], r.FormValue("reason"), nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/setVerifier/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to appoint verifiers"))
+			return
+		}
+
+		user, err := app.getUserByUUID(vars["uuid"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user"))
+			return
+		}
+
+		err = app.setVerifier(user, r.FormValue("verifier") != "")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to update verifier"))
+			return
+		}
+
+		action := "revokeVerifier"
+		if r.FormValue("verifier") != "" {
+			action = "grantVerifier"
+		}
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, action, "user", user.UUID, r.FormValue("reason"), user)
+
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/shadowban/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to shadowban"))
+			return
+		}
+
+		user, err := app.getAnyUserByUUID(vars["uuid"])
+		if err != nil || user.UUID == appstate.UUID {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid user"))
+			return
+		}
+
+		shadowban := r.FormValue("shadowban") != ""
+		err = app.setShadowbanned(user, shadowban)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to update shadowban"))
+			return
+		}
+
+		action := "unshadowbanUser"
+		if shadowban {
+			action = "shadowbanUser"
+		}
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, action, "user", user.UUID, r.FormValue("reason"), user)
+
+		back := r.FormValue("back")
+		if !strings.HasPrefix(back, "/admin") {
+			back = "/admin"
+		}
+
+		http.Redirect(w, r, back, http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/banMedia/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to ban media"))
+			return
+		}
+
+		post, err := app.getPostByUUID(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid post to ban media from"))
+			return
+		}
+
+		_, err = app.banMedia(post, r.FormValue("reason"), appstate.UUID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to ban media"))
+			return
+		}
+
+		err = app.deletePost(post, User{UUID: appstate.UUID})
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete post"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "banMedia", "post", post.UUID, r.FormValue("reason"), post)
+		app.closeDeletedReports(ReportGroup{TargetType: "post", TargetUUID: post.UUID, Post: post}, User{UUID: appstate.UUID})
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/unbanMedia/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to unban media"))
+			return
+		}
+
+		err := app.unbanMedia(vars["uuid"])
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to unban media"))
+			return
+		}
+
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "unbanMedia", "media", vars["uuid"], r.FormValue("reason"), nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	r.HandleFunc("/admin/messages", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		reports, err := app.getMessageReports(50, 0)
+		if err != nil {
+			reports = make([]MessageReport, 0)
+		}
+
+		type reportedMessage struct {
+			Report MessageReport
+			Message Message
+			Context []Message
+			Gone bool
+		}
+
+		reported := make([]reportedMessage, 0, len(reports))
+		for _, report := range reports {
+			message, err := app.getMessageByUUID(report.MessageUUID)
+			if err != nil {
+				// deleteMessage resolves its reports, anything left over a moderator dismisses by hand
+				reported = append(reported, reportedMessage{Report: report, Gone: true})
+				continue
+			}
+
+			// the reported message and a few before it so moderators see what it was replying to
+			context, err := app.getMessages(Conversation{UUID: message.ConversationUUID}, User{UUID: appstate.UUID}, 5, message.Seq + 1)
+			if err != nil {
+				context = make([]Message, 0)
+			}
+
+			reported = append(reported, reportedMessage{Report: report, Message: message, Context: context})
+		}
+
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Reports": reported,
+		}
+
+		tmplAdminMessages.Execute(w, data)
+	})
+
+	r.HandleFunc("/admin/reports", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
+		}
+
+		groups, err := app.getReportGroups(50, 0)
+		if err != nil {
+			groups = make([]ReportGroup, 0)
+		}
+
+		queue := make([]ReportGroup, 0, len(groups))
+		for _, group := range groups {
+			// removed before deletes closed reports, a moderator closes these by hand
+			group.Gone = app.loadReportTarget(&group) != nil
 
-		if err == gorm.ErrRecordNotFound {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("false"))
-		} else if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("error"))
-		} else {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("true"))
+			group.Reports, err = app.getReportsByTarget(group.TargetUUID)
+			if err != nil {
+				group.Reports = make([]Report, 0)
+			}
+
+			queue = append(queue, group)
 		}
-	})
 
-	r.HandleFunc("/signup", func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
-			"ApplicationState": app.genAppState(r),
+			"ApplicationState": appstate,
+			"Queue": queue,
 		}
-		tmplSignUp.Execute(w, data)
+
+		tmplAdminReports.Execute(w, data)
 	})
 
-	r.HandleFunc("/signupForm", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			w.WriteHeader(http.StatusNotFound)
+	r.HandleFunc("/admin/reports/{uuid}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to resolve reports"))
 			return
 		}
-		handle := r.FormValue("handle")
-		name := r.FormValue("name")
-		password := r.FormValue("password")
-		bio := r.FormValue("bio")
 
-		if handle == "" {
+		reports, err := app.getReportsByTarget(vars["uuid"])
+		if err != nil || len(reports) == 0 {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("missing handle"))
+			w.Write([]byte("Nothing open to resolve"))
 			return
 		}
 
-		if name == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("missing name"))
+		moderator := User{UUID: appstate.UUID, Name: appstate.UserName}
+
+		group := ReportGroup{TargetType: reports[0].TargetType, TargetUUID: vars["uuid"], Reports: reports}
+		if err := app.loadReportTarget(&group); err != nil {
+			if vars["action"] != "close" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("The reported content is already gone"))
+				return
+			}
+
+			err = app.closeReports(group, "deleted", moderator)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to close reports"))
+				return
+			}
+			app.audit(moderator, "closeReports", group.TargetType, group.TargetUUID, strings.TrimSpace(r.FormValue("note")), group)
+
+			http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
 			return
 		}
 
-		if password == "" {
+		days, _ := strconv.Atoi(r.FormValue("days"))
+
+		err = app.moderateReport(group, vars["action"], strings.TrimSpace(r.FormValue("note")), days, moderator)
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("missing password"))
+			w.Write([]byte("Failed to resolve reports: " + err.Error()))
 			return
 		}
 
-		user := User {
-			Name: name,
-			Handle: handle,
+		http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+	}).Methods("POST")
+
+	r.HandleFunc("/admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		query := r.URL.Query()
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
+			return
 		}
 
-		if bio != "" {
-			user.Bio = bio
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page < 0 {
+			page = 0
 		}
 
-		uuid, err := app.createUser(user, password)
+		search := strings.TrimSpace(query.Get("q"))
+		action := query.Get("action")
 
+		entries, err := app.searchAuditLog(search, action, 50, page * 50)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("something went wrong"))
-			return
+			entries = make([]AuditEntry, 0)
 		}
 
-		cookie, err := app.signIn(uuid, password)
-
+		actions, err := app.getAuditActions()
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("something went wrong"))
-			return
+			actions = make([]string, 0)
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name: "auth",
-			Value: cookie,
-		})
-		
-		http.Redirect(w, r, "/user/" + uuid, http.StatusSeeOther)
-	}).Methods("POST")
+		nextPage := ""
+		if len(entries) == 50 {
+			nextPage = url.Values{"q": {search}, "action": {action}, "page": {strconv.Itoa(page + 1)}}.Encode()
+		}
 
-	r.HandleFunc("/submitComment", func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Entries": entries,
+			"Actions": actions,
+			"Query": search,
+			"Action": action,
+			"Export": url.Values{"q": {search}, "action": {action}}.Encode(),
+			"NextPage": nextPage,
+		}
+
+		tmplAdminAudit.Execute(w, data)
+	})
+
+	r.HandleFunc("/admin/automod", func(w http.ResponseWriter, r *http.Request) {
 		appstate := app.genAppState(r)
-		if !appstate.SignedIn {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Sign in to comment"))
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
 			return
 		}
 
-		var comment Comment
-
-		comment.Content = r.FormValue("content")
-		comment.PostUUID = r.FormValue("post")
-		comment.UserUUID = appstate.UUID
-		comment.UserName = appstate.UserName
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 0 {
+			page = 0
+		}
 
-		_, err := app.createComment(comment)
+		rules, err := app.getAutomodRules("")
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to create comment"))
-			return
+			rules = make([]AutomodRule, 0)
 		}
 
-		http.Redirect(w, r, "/post/" + comment.PostUUID, http.StatusSeeOther)	
-	})
+		posts, err := app.getWithheldPosts(50, 0)
+		if err != nil {
+			posts = make([]Post, 0)
+		}
 
-	r.HandleFunc("/submitPost", func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("auth")
+		comments, err := app.getWithheldComments(50, 0)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("Sign in to post"))
-			return
+			comments = make([]Comment, 0)
 		}
-		user, err := app.validateCookie(cookie.Value)
+
+		hits, err := app.getAutomodHits(50, page * 50)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Invalid cookie"))
-			return
+			hits = make([]AutomodHit, 0)
 		}
 
-		var post Post
+		nextPage := 0
+		if len(hits) == 50 {
+			nextPage = page + 1
+		}
 
-		post.Title = r.FormValue("title")
-		post.Description = r.FormValue("description")
+		data := map[string]interface{}{
+			"ApplicationState": appstate,
+			"Rules": rules,
+			"Kinds": automodKinds,
+			"Actions": automodActions,
+			"Posts": posts,
+			"Comments": comments,
+			"Hits": hits,
+			"NextPage": nextPage,
+		}
 
-		temp_weight, _ := strconv.Atoi(r.FormValue("weight"))
-		post.Weight = int(temp_weight)
-		post.Lift= r.FormValue("lift")
+		tmplAdminAutomod.Execute(w, data)
+	})
 
-		post.UserUUID = user.UUID
-		post.UserName = user.Name
-		
-		file, _, err := r.FormFile("thumbnail")
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Insert an image"))
+	r.HandleFunc("/admin/automod/rules", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to manage automod"))
 			return
 		}
-		defer file.Close()
 
-		post_uuid, err := app.createPost(post)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to submit post due to internal error"))
-			return
+		threshold, _ := strconv.Atoi(r.FormValue("threshold"))
+		window, _ := strconv.Atoi(r.FormValue("window"))
+
+		rule := AutomodRule{
+			Name: strings.TrimSpace(r.FormValue("name")),
+			Kind: r.FormValue("kind"),
+			Pattern: strings.TrimSpace(r.FormValue("pattern")),
+			Threshold: threshold,
+			Window: window,
+			Applies: r.FormValue("applies"),
+			Action: r.FormValue("action"),
+			Enabled: true,
 		}
 
+		if err := rule.validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
 
-		f, err := os.OpenFile("upload/post/" + post_uuid, os.O_WRONLY|os.O_CREATE, 0666)
+		var err error
+		rule.UUID, err = app.createAutomodRule(rule)
 		if err != nil {
-			panic(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to save rule"))
+			return
 		}
-		defer f.Close()
-		_, _ = io.Copy(f, file)
 
-		http.Redirect(w, r, "/post/" + post_uuid, http.StatusSeeOther)
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "createAutomodRule", "automodRule", rule.UUID, "", rule)
 
-	})
+		http.Redirect(w, r, "/admin/automod", http.StatusSeeOther)
+	}).Methods("POST")
 
-	r.HandleFunc("/deleteUser/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/admin/automod/rules/{uuid}/{action}", func(w http.ResponseWriter, r *http.Request) {
 		appstate := app.genAppState(r)
 		vars := mux.Vars(r)
 
-		if !appstate.SignedIn {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Sign in to delete users")))
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to manage automod"))
 			return
 		}
 
-		user, err := app.getUserByUUID(vars["uuid"])
-
+		rule, err := app.getAutomodRule(vars["uuid"])
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Provide a valid user to delete"))
+			w.Write([]byte("Provide a valid rule"))
 			return
 		}
 
-		if user.UUID != appstate.UUID && !appstate.Moderator {
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte("Must be owner to delete"))
+		var logged string
+		switch vars["action"] {
+		case "enable":
+			logged = "enableAutomodRule"
+			err = app.setAutomodRuleEnabled(rule, true)
+		case "disable":
+			logged = "disableAutomodRule"
+			err = app.setAutomodRuleEnabled(rule, false)
+		case "delete":
+			logged = "deleteAutomodRule"
+			err = app.deleteAutomodRule(rule)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Unknown action"))
 			return
 		}
-
-		err = app.deleteUser(user)
-
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to delete post"))
+			w.Write([]byte("Failed to update rule"))
 			return
 		}
 
-		w.WriteHeader(http.StatusNoContent)
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, logged, "automodRule", rule.UUID, "", rule)
+
+		http.Redirect(w, r, "/admin/automod", http.StatusSeeOther)
 	}).Methods("POST")
 
-	r.HandleFunc("/deletePost/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/admin/automod/review/{type}/{uuid}/{action}", func(w http.ResponseWriter, r *http.Request) {
 		appstate := app.genAppState(r)
 		vars := mux.Vars(r)
 
-		if !appstate.SignedIn {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Sign in to delete posts")))
+		if !appstate.SignedIn || !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be a moderator to review automod"))
 			return
 		}
 
-		post, err := app.getPostByUUID(vars["uuid"])
-
-		if err != nil {
+		moderator := User{UUID: appstate.UUID, Name: appstate.UserName}
+		publish := vars["action"] == "publish"
+		if !publish && vars["action"] != "remove" {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Provide a valid post to delete"))
+			w.Write([]byte("Unknown action"))
 			return
 		}
 
-		if post.UserUUID != appstate.UUID && !appstate.Moderator {
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte("Must be owner to delete"))
-			return
-		}
+		switch vars["type"] {
+		case "post":
+			post, err := app.getPostByUUID(vars["uuid"])
+			if err != nil || post.Automod == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Provide a withheld post"))
+				return
+			}
+
+			if publish {
+				err = app.publishPost(post)
+				if err == nil {
+					err = app.recordMarkup(post.UUID, "post", post.UUID, post.Description, User{UUID: post.UserUUID, Name: post.UserName})
+				}
+				app.audit(moderator, "publishPost", "post", post.UUID, "", post)
+			} else {
+				err = app.deletePost(post, moderator)
+				if err == nil {
+					app.closeDeletedReports(ReportGroup{TargetType: "post", TargetUUID: post.UUID, Post: post}, moderator)
+				}
+				app.audit(moderator, "deletePost", "post", post.UUID, "automod", post)
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to review post"))
+				return
+			}
+		case "comment":
+			comment, err := app.getCommentByUUID(vars["uuid"])
+			if err != nil || comment.Automod == "" || comment.Deleted {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Provide a withheld comment"))
+				return
+			}
 
-		err = app.deletePost(post)
+			if publish {
+				post, err := app.getPostByUUID(comment.PostUUID)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("The post is gone"))
+					return
+				}
+				var parent Comment
+				if comment.ParentUUID != "" {
+					parent, _ = app.getCommentByUUID(comment.ParentUUID)
+				}
 
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to delete post"))
+				err = app.publishComment(comment)
+				if err == nil {
+					app.announceComment(comment, post, parent)
+				}
+				app.audit(moderator, "publishComment", "comment", comment.UUID, "", comment)
+			} else {
+				err = app.deleteComment(comment, moderator)
+				if err == nil {
+					app.closeDeletedReports(ReportGroup{TargetType: "comment", TargetUUID: comment.UUID, Comment: comment}, moderator)
+				}
+				app.audit(moderator, "deleteComment", "comment", comment.UUID, "automod", comment)
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Failed to review comment"))
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Only posts and comments are withheld"))
 			return
 		}
 
-		w.WriteHeader(http.StatusNoContent)
+		http.Redirect(w, r, "/admin/automod", http.StatusSeeOther)
 	}).Methods("POST")
 
-	r.HandleFunc("/deleteComment/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+	// the same search as /admin/audit without paging, as CSV for attaching to disputes
+	r.HandleFunc("/admin/audit/export", func(w http.ResponseWriter, r *http.Request) {
 		appstate := app.genAppState(r)
-		vars := mux.Vars(r)
+		query := r.URL.Query()
 
-		if !appstate.SignedIn {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(("Sign in to delete comments")))
+		if !appstate.SignedIn || !appstate.Moderator {
+			app.NotFoundHandler(w, r)
 			return
 		}
 
-		comment, err := app.getCommentByUUID(vars["uuid"])
-
+		entries, err := app.searchAuditLog(strings.TrimSpace(query.Get("q")), query.Get("action"), -1, 0)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Provide a valid comment to delete"))
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to export audit log"))
 			return
 		}
 
-		if comment.UserUUID != appstate.UUID && !appstate.Moderator {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"audit-" + time.Now().Format("2006-01-02") + ".csv\"")
+
+		out := csv.NewWriter(w)
+		out.Write([]string{"time", "actor_uuid", "actor_name", "action", "target_type", "target_uuid", "reason", "snapshot"})
+		for _, entry := range entries {
+			out.Write([]string{
+				entry.CreatedAt.Format(time.RFC3339),
+				entry.ActorUUID,
+				entry.ActorName,
+				entry.Action,
+				entry.TargetType,
+				entry.TargetUUID,
+				entry.Reason,
+				entry.Snapshot,
+			})
+		}
+		out.Flush()
+	})
+
+	r.HandleFunc("/resolveMessageReport/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
+
+		if !appstate.SignedIn || !appstate.Moderator {
 			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte("Must be owner to delete"))
+			w.Write([]byte("Must be a moderator to resolve reports"))
 			return
 		}
 
-		err = app.deleteComment(comment)
+		err := app.resolveMessageReport(vars["uuid"])
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Failed to delete comment"))
+			w.Write([]byte("Failed to resolve report"))
 			return
 		}
 
+		app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "dismissMessageReport", "report", vars["uuid"], r.FormValue("reason"), nil)
+
 		w.WriteHeader(http.StatusNoContent)
 	}).Methods("POST")
 
-	r.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/deleteMessage/{uuid}", func(w http.ResponseWriter, r *http.Request) {
 		appstate := app.genAppState(r)
+		vars := mux.Vars(r)
 
-		if !appstate.SignedIn || !appstate.Moderator {
-			app.NotFoundHandler(w, r)
+		if !appstate.SignedIn {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Sign in to delete messages"))
 			return
 		}
 
-		posts, err := app.getTopPosts(10, 0)
+		message, err := app.getMessageByUUID(vars["uuid"])
+
 		if err != nil {
-			posts = make([]Post, 0)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Provide a valid message to delete"))
+			return
 		}
 
-		users, err := app.getUsers(10, 0)
-		if err != nil {
-			users = make([]User, 0)
+		if message.UserUUID != appstate.UUID && !appstate.Moderator {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Must be owner to delete"))
+			return
 		}
 
-		comments, err := app.getAllComments(10, 0)
+		err = app.deleteMessage(message)
+
 		if err != nil {
-			comments = make([]Comment, 0)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to delete message"))
+			return
 		}
 
-		data := map[string]interface{}{
-			"ApplicationState": appstate,
-			"Posts": posts,
-			"Users": users,
-			"Comments": comments,
+		if message.UserUUID != appstate.UUID {
+			app.audit(User{UUID: appstate.UUID, Name: appstate.UserName}, "deleteMessage", "message", message.UUID, r.FormValue("reason"), message)
 		}
 
-		tmplAdmin.Execute(w, data)
-	})
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
 
 	r.HandleFunc("/logOut", func(w http.ResponseWriter, r *http.Request) {
 		appstate := app.genAppState(r)
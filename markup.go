@@ -0,0 +1,76 @@
+package main
+
+import (
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// @handle and #tag, only where they start a word so emails and anchors are left alone
+var markupPattern = regexp.MustCompile(`(^|[^\w@#&])([@#])(\w+)`)
+
+var templateFuncs = template.FuncMap{
+	"markup": markup,
+	"reactionTypes": func() interface{} { return reactionTypes },
+	"ordinal": ordinal,
+}
+
+// ParseFiles with the helpers every page needs, the first file names the template
+func parseTemplate(files ...string) (*template.Template, error) {
+	return template.New(filepath.Base(files[0])).Funcs(templateFuncs).ParseFiles(files...)
+}
+
+// Handles mentioned in text, without the @ and without repeats
+func parseMentions(text string) []string {
+	return parseMarkup(text, "@", false)
+}
+
+// Tags in text, lowercased, without the # and without repeats
+func parseTags(text string) []string {
+	return parseMarkup(text, "#", true)
+}
+
+func parseMarkup(text string, sigil string, lower bool) []string {
+	seen := make(map[string]bool)
+	var found []string
+
+	for _, match := range markupPattern.FindAllStringSubmatch(text, -1) {
+		if match[2] != sigil {
+			continue
+		}
+		name := match[3]
+		if lower {
+			name = strings.ToLower(name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			found = append(found, name)
+		}
+	}
+
+	return found
+}
+
+// Escapes text and turns mentions and tags into links
+func markup(text string) template.HTML {
+	var out strings.Builder
+	last := 0
+
+	for _, match := range markupPattern.FindAllStringSubmatchIndex(text, -1) {
+		// match[4] is where the @ or # starts, everything before it is plain text
+		out.WriteString(template.HTMLEscapeString(text[last:match[4]]))
+
+		name := text[match[6]:match[7]]
+		if text[match[4]] == '@' {
+			out.WriteString(`<a href="/u/` + template.URLQueryEscaper(name) + `" class="mention">@` + template.HTMLEscapeString(name) + `</a>`)
+		} else {
+			out.WriteString(`<a href="/tag/` + template.URLQueryEscaper(strings.ToLower(name)) + `" class="tag">#` + template.HTMLEscapeString(name) + `</a>`)
+		}
+
+		last = match[1]
+	}
+	out.WriteString(template.HTMLEscapeString(text[last:]))
+
+	return template.HTML(out.String())
+}
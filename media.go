@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// biggest upload accepted by /submitPost, the form fields ride along with the file
+const maxUploadSize = 50 << 20
+
+// media types we accept, sniffed from the file itself and never from the client
+var allowedMediaTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+}
+
+var errUnsupportedMedia = errors.New("unsupported media type")
+var errCorruptMedia = errors.New("media failed to decode")
+
+// Reads an uploaded file and returns its bytes and sniffed media type
+//
+// rejects anything not in allowedMediaTypes or that doesn't decode as what it claims to be
+func readUpload(file io.Reader) ([]byte, string, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mediaType := http.DetectContentType(data)
+	if _, ok := allowedMediaTypes[mediaType]; !ok {
+		return nil, "", errUnsupportedMedia
+	}
+
+	switch mediaType {
+	case "video/mp4":
+		err = checkMP4(data)
+	case "video/webm":
+		// the sniffer already matched the EBML header and webm doctype
+	default:
+		_, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", errCorruptMedia
+	}
+
+	return data, mediaType, nil
+}
+
+// walks the top level boxes of an mp4 and makes sure they add up and a movie header exists
+func checkMP4(data []byte) error {
+	hasMoov := false
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errCorruptMedia
+		}
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			// box runs to the end of the file
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errCorruptMedia
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return errCorruptMedia
+		}
+		if string(data[4:8]) == "moov" {
+			hasMoov = true
+		}
+		data = data[size:]
+	}
+
+	if !hasMoov {
+		return errCorruptMedia
+	}
+	return nil
+}
+
+// uploads are stored by the sha256 of their contents so duplicates share one file
+const mediaDir = "upload/post/"
+
+// held while blobs are written or collected so the GC can't remove a file an upload is reusing
+var mediaLock sync.Mutex
+
+func mediaPath(hash string) string {
+	return mediaDir + hash
+}
+
+// The name upload bytes are stored under, known before anything is written
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Writes upload bytes to the media store and returns their hash
+//
+// caller must hold mediaLock until the post referencing the blob is created, then call it again:
+// mediaLock only covers this process and a -gc run may have collected the file it found
+func storeBlob(data []byte) (string, error) {
+	hash := blobHash(data)
+
+	if _, err := os.Stat(mediaPath(hash)); err == nil {
+		return hash, nil
+	}
+
+	f, err := os.CreateTemp(mediaDir, ".staging-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := os.Rename(f.Name(), mediaPath(hash)); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// Serves a post's media with headers that stop the browser treating it as anything but media
+func serveMedia(w http.ResponseWriter, r *http.Request, post Post) error {
+	if post.MediaHash == "" {
+		return os.ErrNotExist
+	}
+
+	f, err := os.Open(mediaPath(post.MediaHash))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	mediaType := post.MediaType
+	disposition := "inline"
+	ext, ok := allowedMediaTypes[mediaType]
+	if !ok {
+		mediaType = "application/octet-stream"
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", disposition+`; filename="`+post.UUID+ext+`"`)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+	return nil
+}
+
+// Moves files saved under their post UUID into the media store
+//
+// runs at startup, posts that already have a hash are skipped
+func (a App) migrateLegacyMedia() error {
+	var posts []Post
+
+	err := a.DB.Table("Posts").Where("media_hash = ? OR media_hash IS NULL", "").Find(&posts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		data, err := os.ReadFile(mediaDir + post.UUID)
+		if err != nil {
+			continue
+		}
+
+		mediaLock.Lock()
+		hash, err := storeBlob(data)
+		if err == nil {
+			post.MediaHash = hash
+			if post.MediaType == "" {
+				post.MediaType = http.DetectContentType(data)
+			}
+			err = a.attachMedia(post)
+		}
+		if err == nil {
+			_, err = storeBlob(data)
+		}
+		mediaLock.Unlock()
+
+		if err != nil {
+			return err
+		}
+		os.Remove(mediaDir + post.UUID)
+	}
+
+	return nil
+}
+
+// Removes blobs that have had no references for longer than grace
+//
+// also sweeps files with no media row at all, left behind when a post failed to save
+func (a App) collectMedia(grace time.Duration) (int, int64, error) {
+	removed := 0
+	var reclaimed int64
+	cutoff := time.Now().Add(-grace)
+
+	orphans, err := a.getOrphanedMedia(cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, media := range orphans {
+		mediaLock.Lock()
+		var size int64
+		if info, statErr := os.Stat(mediaPath(media.Hash)); statErr == nil {
+			size = info.Size()
+		}
+		gone, err := a.deleteMedia(media, func() error {
+			if err := os.Remove(mediaPath(media.Hash)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+		if gone {
+			removed++
+			reclaimed += size
+		}
+		mediaLock.Unlock()
+
+		if err != nil {
+			return removed, reclaimed, err
+		}
+	}
+
+	entries, err := os.ReadDir(mediaDir)
+	if err != nil {
+		return removed, reclaimed, err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		name := entry.Name()
+		if !strings.HasPrefix(name, ".staging-") && len(name) != sha256.Size*2 {
+			// legacy upload the migration couldn't match to a post, leave it alone
+			continue
+		}
+
+		mediaLock.Lock()
+		known, err := a.mediaExists(name)
+		if err == nil && !known {
+			if err = os.Remove(mediaDir + name); err == nil {
+				removed++
+				reclaimed += info.Size()
+			}
+		}
+		mediaLock.Unlock()
+
+		if err != nil {
+			return removed, reclaimed, err
+		}
+	}
+
+	return removed, reclaimed, nil
+}
+
+// Runs collectMedia on an interval for the life of the server
+func (a App) mediaCollector(interval time.Duration, grace time.Duration) {
+	for {
+		removed, reclaimed, err := a.collectMedia(grace)
+		if err != nil {
+			fmt.Println("Media GC failed:", err)
+		} else if removed > 0 {
+			fmt.Printf("Media GC removed %d blobs, reclaimed %d bytes\n", removed, reclaimed)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// used by the postcard template to pick between <img> and <video>
+func (p Post) IsVideo() bool {
+	return p.MediaType == "video/mp4" || p.MediaType == "video/webm"
+}
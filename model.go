@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 //data models used in the database and frontend
 
 type User struct {
@@ -10,11 +12,72 @@ type User struct {
 	UUID string `gorm:"unique"`
 
 	Moderator bool
+	Verifier bool //trusted to vote on any lift's verification, not just their gym's
+	Shadowbanned bool //everything they do is visible to them and moderators only
+
+	Deleted bool //hidden and signed out, restorable until the retention window passes
+	DeletedAt time.Time
+	DeletedBy string //the user themselves or a moderator
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
+// one reaction per user per post, the table kept its name from when likes were the only kind
 type Like struct {
 	PostUUID string
 	UserUUID string
+	Type string //a key of reactionColumns
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// a post or comment as it was before an edit
+type Revision struct {
+	UUID string `gorm:"unique"`
+	TargetUUID string
+	TargetType string //post or comment
+	EditorUUID string //who made the edit that replaced this version
+
+	Title string
+	Description string
+	Weight int
+	Reps int
+	Sets int
+	Lift string
+	Content string
+
+	CreatedAt time.Time
+}
+
+// a user @mentioned in a post description or comment
+type Mention struct {
+	SourceUUID string //post or comment the mention is in
+	SourceType string
+	PostUUID string
+	UserUUID string
+}
+
+// a #tag in a post description or comment, the tag page lists the posts
+type Tag struct {
+	Name string //lowercase, without the #
+	SourceUUID string
+	PostUUID string
+}
+
+// Kind block also stops them commenting, reacting, mentioning or messaging, mute only hides them
+type Block struct {
+	UserUUID string
+	BlockedUUID string
+	Kind string //block or mute
+
+	CreatedAt time.Time
+}
+
+type Follow struct {
+	FollowerUUID string
+	FolloweeUUID string
 }
 
 type Comment struct {
@@ -22,8 +85,34 @@ type Comment struct {
 	UUID string
 
 	PostUUID string
+	ParentUUID string //empty for top level comments
 	UserUUID string
 	UserName string
+
+	Deleted bool //shown as a [deleted] placeholder while it has replies, restorable until purged
+	DeletedAt time.Time
+	DeletedBy string
+	Automod string //same as on posts
+	EditedAt time.Time //zero until first edit
+
+	Timed bool //points at a moment in the post's video
+	Timestamp float64 //seconds into the video
+	RegionX float64 //optional box on that frame, percent of the frame, zero width means none
+	RegionY float64
+	RegionW float64
+	RegionH float64
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Replies []Comment `gorm:"-"` //filled by threadComments
+	Continues bool `gorm:"-"` //has replies past maxCommentDepth, link to the thread instead
+	Owner bool `gorm:"-"`
+	History bool `gorm:"-"` //viewer is a moderator and there are revisions to look at
+	Accepted bool `gorm:"-"` //the post author's accepted answer
+	CanAccept bool `gorm:"-"` //viewer wrote the form check this is on
+	Video bool `gorm:"-"` //post has a video, so replies can be timestamped
+	CanRestore bool `gorm:"-"` //deleted and the viewer is allowed to bring it back
 }
 
 type Post struct {
@@ -32,15 +121,384 @@ type Post struct {
 	
 	Weight int
 	Lift string
+	Reps int //0 on posts from before reps were recorded, counted as 1
+	Sets int
 	UUID string `gorm:"unique"`
-	Likes int
+	Likes int //every reaction, whatever the type
+	Claps int
+	Fires int
+	Strongs int
+	FormChecks int
 	Comments int
+	MediaType string //sniffed at upload, served back as Content-Type
+	MediaHash string //sha256 of the upload, names the file in upload/post/
+	PerceptualHash string //dhash of the image or video keyframe, empty if none could be made
+	GymUUID string //gym the lift was done at, empty if untagged
+	GymName string
+	Verification string //"" never asked, pending, verified or rejected
+	Judging string //"" never submitted, open while the panel sits, then good or nolift
+	FormCheck bool //author is asking for feedback on their form
+	AcceptedUUID string //comment the author marked as the answer, a form check with one is resolved
+	Deleted bool //hidden everywhere, restorable until the retention window passes
+	DeletedAt time.Time
+	DeletedBy string
+	Automod string //"" published, held until a moderator approves it, hidden from everyone but the author
 	
 	UserUUID string
 	UserName string
 
-	Liked bool `gorm:"-"` //shitty hack for passing thru to postcard template
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	EditedAt time.Time //zero until first edit
+
+	Seq int64 `gorm:"->;-:migration"` //sqlite rowid, only filled by feed queries that select it as seq
+
+	Reaction string `gorm:"-"` //shitty hack for passing thru to postcard template, viewer's reaction type
 	Owner bool `gorm:"-"` //same shit
+	Bookmarked bool `gorm:"-"` //viewer has it saved
+}
+
+type Gym struct {
+	UUID string `gorm:"unique"`
+	Name string
+	Location string
+	Description string
+	Members int //approved members only
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type GymMember struct {
+	GymUUID string
+	UserUUID string
+	UserName string
+	Admin bool
+	Approved bool //pending until a gym admin lets them in
+
+	CreatedAt time.Time
+}
+
+// a lifter's best post for one lift
+type LeaderboardEntry struct {
+	UserUUID string
+	UserName string
+	PostUUID string
+	Weight int
+}
+
+// one witness's vote on a lift, it only counts towards verified if both boxes are ticked
+type VerificationVote struct {
+	PostUUID string
+	UserUUID string
+	UserName string
+	Legit bool //the weight and the lift are real
+	Standard bool //hit depth and lockout
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// one referee's seat on a lift's panel
+type Judgement struct {
+	PostUUID string
+	JudgeUUID string
+	JudgeName string
+	Seat int //1 to judgingSeats, left to right
+	Light string //white or red, empty until they vote
+	Faults string //comma separated faultCodes behind a red light
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// how often a judge's light agreed with the panel's result
+type JudgeStats struct {
+	Judged int
+	Agreed int
+}
+
+// a named folder of bookmarks, private ones are only shown to their owner
+type Collection struct {
+	UUID string `gorm:"unique"`
+	UserUUID string
+	Name string
+	Public bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Bookmark struct {
+	UserUUID string
+	PostUUID string
+	CollectionUUID string //empty for bookmarks not filed anywhere yet
+
+	CreatedAt time.Time
+}
+
+type Challenge struct {
+	UUID string `gorm:"unique"`
+	Title string
+	Description string
+	Tag string //posts enter with #tag, lowercase without the #
+	Scoring string //max, volume, reps or points, see challengeScoring
+	Lift string //only this lift counts, empty for any
+	MinWeight int //lighter posts don't count
+	VerifiedOnly bool //only verified lifts count
+	GymUUID string //only posts tagged with this gym count, empty for everyone
+	GymName string
+
+	CreatorUUID string
+	CreatorName string
+
+	StartsAt time.Time
+	EndsAt time.Time
+	Closed bool //standings are final and badges handed out
+	WinnerUUID string
+	WinnerName string
+
+	CreatedAt time.Time
+}
+
+// a lifter's place in a challenge, worked out from their entries every time it's shown
+type Standing struct {
+	UserUUID string
+	UserName string
+	Score int
+	Entries int
+	Place int `gorm:"-"`
+}
+
+// handed to the top three when a challenge closes
+type Badge struct {
+	UserUUID string
+	ChallengeUUID string
+	Title string //the challenge's title when it closed
+	Place int
+
+	CreatedAt time.Time
+}
+
+// one row per stored upload, shared by every post with the same bytes
+type Media struct {
+	Hash string `gorm:"unique"`
+	MediaType string
+
+	RefCount int
+	OrphanedAt time.Time //when RefCount last hit zero, GC waits out a grace period from here
+}
+
+// a post whose media looks like another user's, waiting on a moderator
+type DuplicateFlag struct {
+	UUID string `gorm:"unique"`
+	PostUUID string //the newer upload
+	MatchUUID string //the existing post it resembles
+	Distance int //bits of perceptual hash that differ, 0 is identical
+
+	Resolved bool
+}
+
+// media moderators have banned, uploads matching either hash are rejected
+type BannedMedia struct {
+	UUID string `gorm:"unique"`
+	Hash string
+	PerceptualHash string
+	Reason string
+
+	ModeratorUUID string
+}
+
+// a direct message thread between two or more users
+type Conversation struct {
+	UUID string `gorm:"unique"`
+	Title string //optional, group chats only
+
+	CreatedAt time.Time
+	UpdatedAt time.Time //bumped on every message so the inbox sorts by activity
+}
+
+type ConversationMember struct {
+	ConversationUUID string
+	UserUUID string
+	UserName string
+
+	LastReadSeq int64 //rowid of the last message this member has seen
+	Muted bool //left out of the topbar unread count
+}
+
+type Message struct {
+	UUID string `gorm:"unique"`
+	ConversationUUID string
+	UserUUID string
+	UserName string
+	Content string
+
+	CreatedAt time.Time
+
+	Seq int64 `gorm:"->;-:migration"` //sqlite rowid, filled by queries that select it as seq
+}
+
+// a message a member flagged for moderators
+type MessageReport struct {
+	UUID string `gorm:"unique"`
+	MessageUUID string
+	ReporterUUID string
+	Reason string
+
+	Resolved bool
+
+	CreatedAt time.Time
+}
+
+// a post, comment or user someone flagged for moderators, reports on the same target form one queue item
+type Report struct {
+	UUID string `gorm:"unique"`
+	TargetType string //post, comment or user
+	TargetUUID string
+	ReporterUUID string
+	Category string //a key of reportCategories
+	Details string
+
+	Resolved bool
+	Resolution string //dismissed, deleted, warned or suspended
+	ModeratorUUID string //who resolved it
+
+	CreatedAt time.Time
+	ResolvedAt time.Time
+}
+
+// unresolved reports collapsed by target for the moderation queue, not a table
+type ReportGroup struct {
+	TargetType string
+	TargetUUID string
+	Reporters int //distinct users who reported it
+
+	Reports []Report `gorm:"-"`
+	Post Post `gorm:"-"`
+	Comment Comment `gorm:"-"`
+	User User `gorm:"-"`
+	Gone bool `gorm:"-"` //the target no longer exists
+}
+
+// a user who can read but not post, comment or react until it expires
+//
+// a ban also signs them out, keeps them from signing in and refuses signups with their email
+type Suspension struct {
+	UUID string `gorm:"unique"`
+	UserUUID string
+	ModeratorUUID string
+	Kind string //suspension or ban, empty on suspensions from before bans
+	Reason string
+	Email string //the banned account's email, empty for suspensions
+
+	CreatedAt time.Time
+	ExpiresAt time.Time //ignored when Permanent
+	Permanent bool //bans only
+}
+
+// one thing a moderator or admin did, kept so disputes can be settled after the content is gone
+type AuditEntry struct {
+	UUID string `gorm:"unique"`
+	ActorUUID string
+	ActorName string
+	Action string //e.g. deletePost, suspendUser, banMedia
+	TargetType string
+	TargetUUID string
+	Reason string
+	Snapshot string //JSON of the target as it was just before the action
+
+	CreatedAt time.Time
+}
+
+// an admin-managed check run on every new post and comment before it's saved
+type AutomodRule struct {
+	UUID string `gorm:"unique"`
+	Name string
+	Kind string //words, regex, links, age or rate
+	Pattern string //one word or phrase per line for words, an RE2 expression for regex
+	Threshold int //most links, youngest account in hours, or most submissions in the window
+	Window int //minutes, rate rules only
+	Applies string //post, comment or "" for both
+	Action string //reject, hold, hide or flag
+	Enabled bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// one time a rule matched, kept whatever the action was
+type AutomodHit struct {
+	UUID string `gorm:"unique"`
+	RuleUUID string
+	RuleName string
+	Action string
+	TargetType string
+	TargetUUID string //empty when the submission was rejected
+	UserUUID string
+	UserName string
+	Excerpt string //start of what was submitted, so rejected ones can still be judged
+
+	CreatedAt time.Time
+}
+
+// what the admin console is showing, read from and written back to its query string, not a table
+type AdminSearch struct {
+	Table string //users, posts, comments or reports
+	Query string
+	User string //handle or UUID
+	Status string //one of adminStatuses for the table, "" for any
+	From time.Time //zero for no bound
+	To time.Time //inclusive, the whole day
+	Sort string //key of adminSortColumns for the table
+	Desc bool
+	Page int
+}
+
+// a conversation as the inbox shows it, not a table
+type ConversationSummary struct {
+	Conversation Conversation
+	Members []ConversationMember
+	Unread int64
+	Muted bool
+}
+
+// one event for one recipient, the notifications page collapses them by type and target
+type Notification struct {
+	UUID string `gorm:"unique"`
+	UserUUID string //recipient
+	Type string //like, comment, reply, follow, mention, accepted, challenge, verified, judge, judged, report, warning or suspended
+	ActorUUID string
+	ActorName string
+	TargetUUID string //what gets collapsed on, the post, comment or user acted on
+	PostUUID string //where clicking the notification goes, empty for follows
+	Note string //moderator's words on report outcomes, warnings and suspensions
+
+	Read bool
+
+	CreatedAt time.Time
+}
+
+// opt outs only, a missing row means the type is enabled
+type NotificationPreference struct {
+	UserUUID string
+	Type string
+	Enabled bool
+}
+
+// collapsed notifications for the notifications page, not a table
+type NotificationGroup struct {
+	Type string
+	TargetUUID string
+	PostUUID string
+	Read bool
+	Actors int //distinct users behind the group
+
+	ActorUUID string //most recent actor
+	ActorName string
+	Latest time.Time
+	PostTitle string
+	Note string //from the most recent event
 }
 
 type ApplicationState struct {
@@ -49,6 +507,9 @@ type ApplicationState struct {
 	UserName string
 	Moderator bool //currently signed in user is moderator?
 	Cookie string
+	Unread int64 //unread notification groups for the topbar badge
+	UnreadMessages int64 //unread direct messages outside muted conversations
+	Suspension Suspension //the one in force, zero when there isn't one, shown as a banner
 }
 
 type Auth struct {
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// who moderation notices come from, nobody can block or mute it so warnings always land
+var moderators = User{Name: "Moderators"}
+
+// what a report can be filed under
+var reportCategories = []struct {
+	Category string
+	Label string
+}{
+	{"spam", "Spam or advertising"},
+	{"harassment", "Harassment or bullying"},
+	{"hate", "Hate speech"},
+	{"violence", "Violence or threats"},
+	{"nudity", "Nudity or sexual content"},
+	{"impersonation", "Impersonation"},
+	{"dangerous", "Dangerous training advice"},
+	{"other", "Something else"},
+}
+
+// what reporters are told when their report is closed
+var reportOutcomes = map[string]string{
+	"dismissed": "found it within the rules",
+	"deleted": "removed it",
+	"warned": "warned its author",
+	"suspended": "suspended its author",
+}
+
+// suspensions from the queue are between a day and a year
+const maxSuspensionDays = 365
+
+func reportCategoryLabel(Category string) string {
+	for _, category := range reportCategories {
+		if category.Category == Category {
+			return category.Label
+		}
+	}
+
+	return ""
+}
+
+// Turns suspended and banned users away from anything that writes, true when it did
+func (a App) refuseSuspended(w http.ResponseWriter, user User) bool {
+	if !a.isSuspended(user) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("Your account is suspended"))
+	return true
+}
+
+// Fills in whatever the group points at, errors when it no longer exists
+func (a App) loadReportTarget(group *ReportGroup) error {
+	var err error
+
+	switch group.TargetType {
+	case "post":
+		group.Post, err = a.getPostByUUID(group.TargetUUID)
+	case "comment":
+		group.Comment, err = a.getCommentByUUID(group.TargetUUID)
+		if err == nil && group.Comment.Deleted {
+			err = errors.New("comment is deleted")
+		}
+	case "user":
+		group.User, err = a.getUserByUUID(group.TargetUUID)
+	default:
+		err = errors.New("unknown report target " + group.TargetType)
+	}
+
+	return err
+}
+
+// Who a warning or suspension lands on
+func (g ReportGroup) AuthorUUID() string {
+	switch g.TargetType {
+	case "post":
+		return g.Post.UserUUID
+	case "comment":
+		return g.Comment.UserUUID
+	}
+
+	return g.User.UUID
+}
+
+// Where notifications about the target link to, empty for users
+func (g ReportGroup) PostUUID() string {
+	switch g.TargetType {
+	case "post":
+		return g.Post.UUID
+	case "comment":
+		return g.Comment.PostUUID
+	}
+
+	return ""
+}
+
+// The category most reporters picked
+func (g ReportGroup) Category() string {
+	counts := make(map[string]int)
+	top := ""
+	for _, report := range g.Reports {
+		counts[report.Category]++
+		if counts[report.Category] > counts[top] {
+			top = report.Category
+		}
+	}
+
+	return top
+}
+
+// Carries out a queue action on a loaded group, closes its reports and tells the reporters
+//
+// Note is the moderator's explanation, the top category stands in when it's empty
+func (a App) moderateReport(group ReportGroup, action string, Note string, Days int, moderator User) error {
+	if Note == "" {
+		Note = reportCategoryLabel(group.Category())
+	}
+
+	var resolution, logged string
+	var err error
+
+	switch action {
+	case "dismiss":
+		resolution, logged = "dismissed", "dismissReports"
+	case "delete":
+		resolution = "deleted"
+		switch group.TargetType {
+		case "post":
+			logged = "deletePost"
+			err = a.deletePost(group.Post, moderator)
+		case "comment":
+			logged = "deleteComment"
+			err = a.deleteComment(group.Comment, moderator)
+		case "user":
+			logged = "deleteUser"
+			err = a.deleteUser(group.User, moderator)
+		}
+	case "warn":
+		resolution, logged = "warned", "warnUser"
+		err = a.notify(Notification{
+			UserUUID: group.AuthorUUID(),
+			Type: "warning",
+			ActorUUID: moderators.UUID,
+			ActorName: moderators.Name,
+			TargetUUID: group.TargetUUID,
+			PostUUID: group.PostUUID(),
+			Note: Note,
+		})
+	case "suspend":
+		resolution, logged = "suspended", "suspendUser"
+		if Days < 1 || Days > maxSuspensionDays {
+			return errors.New("suspensions run from 1 to 365 days")
+		}
+
+		expires := time.Now().AddDate(0, 0, Days)
+		_, err = a.suspendUser(Suspension{
+			UserUUID: group.AuthorUUID(),
+			ModeratorUUID: moderator.UUID,
+			Reason: Note,
+			ExpiresAt: expires,
+		})
+		if err == nil {
+			err = a.notify(Notification{
+				UserUUID: group.AuthorUUID(),
+				Type: "suspended",
+				ActorUUID: moderators.UUID,
+				ActorName: moderators.Name,
+				TargetUUID: group.TargetUUID,
+				PostUUID: group.PostUUID(),
+				Note: "until " + expires.Format("Jan 2, 2006 15:04") + ": " + Note,
+			})
+		}
+	default:
+		return errors.New("unknown action " + action)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	a.audit(moderator, logged, group.TargetType, group.TargetUUID, Note, group)
+
+	return a.closeReports(group, resolution, moderator)
+}
+
+// Resolves the group's reports and tells each reporter the outcome
+func (a App) closeReports(group ReportGroup, resolution string, moderator User) error {
+	reporters, err := a.resolveReports(group.TargetUUID, resolution, moderator)
+	if err != nil {
+		return err
+	}
+
+	postUUID := group.PostUUID()
+	if resolution == "deleted" && group.TargetType == "post" {
+		postUUID = ""
+	}
+
+	for _, reporter := range reporters {
+		if reporter == "" {
+			// flagged by automod
+			continue
+		}
+
+		err = a.notify(Notification{
+			UserUUID: reporter,
+			Type: "report",
+			ActorUUID: moderators.UUID,
+			ActorName: moderators.Name,
+			TargetUUID: group.TargetUUID,
+			PostUUID: postUUID,
+			Note: reportOutcomes[resolution],
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Closes the open reports on something deleted outside the queue, the reporters hear it's gone
+//
+// by is whoever deleted it, failures are printed since the delete itself went through
+func (a App) closeDeletedReports(group ReportGroup, by User) {
+	if err := a.closeReports(group, "deleted", by); err != nil {
+		fmt.Println("Failed to close reports on", group.TargetType, group.TargetUUID, err)
+	}
+}
+
+// Logs a moderator or admin action, Target is stored as JSON so the entry outlives it
+//
+// failures are printed rather than returned, the action itself has already happened
+func (a App) audit(actor User, Action string, TargetType string, TargetUUID string, Reason string, Target interface{}) {
+	snapshot := ""
+	if Target != nil {
+		data, err := json.Marshal(Target)
+		if err != nil {
+			fmt.Println("Failed to snapshot", TargetType, TargetUUID, err)
+		}
+		snapshot = string(data)
+	}
+
+	_, err := a.recordAudit(AuditEntry{
+		ActorUUID: actor.UUID,
+		ActorName: actor.Name,
+		Action: Action,
+		TargetType: TargetType,
+		TargetUUID: TargetUUID,
+		Reason: Reason,
+		Snapshot: snapshot,
+	})
+	if err != nil {
+		fmt.Println("Failed to record audit entry", Action, TargetUUID, err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"math/bits"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// hashes within this many differing bits are treated as the same picture
+const duplicateDistance = 10
+
+// sqlite driver with hash_distance(a, b) so near matches are found in the query instead of in Go
+const phashDriver = "sqlite3_phash"
+
+func init() {
+	sql.Register(phashDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("hash_distance", hashDistance, true)
+		},
+	})
+}
+
+// Returns a 64 bit difference hash of the upload as 16 hex chars
+//
+// images are hashed directly, videos hash their first keyframe when ffmpeg is installed,
+// "" means no hash could be made
+func perceptualHash(data []byte, mediaType string) string {
+	var img image.Image
+	var err error
+
+	switch mediaType {
+	case "video/mp4", "video/webm":
+		img, err = videoKeyframe(data)
+	default:
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil || img == nil {
+		return ""
+	}
+
+	hash := dHash(img)
+	if hash == 0 {
+		// flat images all hash to zero, matching on that would flag every blank frame
+		return ""
+	}
+
+	return formatHash(hash)
+}
+
+// Shrinks the image to 9x8 grayscale and records whether each pixel is brighter than its right neighbour
+func dHash(img image.Image) uint64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+
+	var gray [8][9]uint64
+	for y := 0; y < 8; y++ {
+		y0 := bounds.Min.Y + y*h/8
+		y1 := bounds.Min.Y + (y+1)*h/8
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < 9; x++ {
+			x0 := bounds.Min.X + x*w/9
+			x1 := bounds.Min.X + (x+1)*w/9
+			if x1 == x0 {
+				x1++
+			}
+
+			var sum, n uint64
+			for py := y0; py < y1 && py < bounds.Max.Y; py++ {
+				for px := x0; px < x1 && px < bounds.Max.X; px++ {
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+					n++
+				}
+			}
+			if n > 0 {
+				gray[y][x] = sum / n
+			}
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// Pulls the first keyframe out of a video with ffmpeg, errors if ffmpeg isn't on PATH
+//
+// the video goes through a temp file since mp4s can keep their index at the end where a pipe can't seek
+func videoKeyframe(data []byte) (image.Image, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "keyframe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return nil, err
+	}
+	path := f.Name()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, ffmpeg, "-v", "error", "-i", path,
+		"-vf", `select=eq(pict_type\,I)`, "-frames:v", "1",
+		"-f", "image2pipe", "-vcodec", "png", "-").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(out))
+	return img, err
+}
+
+func formatHash(hash uint64) string {
+	s := strconv.FormatUint(hash, 16)
+	for len(s) < 16 {
+		s = "0" + s
+	}
+	return s
+}
+
+// Number of differing bits between two hex hashes, -1 if either is missing or malformed
+func hashDistance(a string, b string) int {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return -1
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return -1
+	}
+
+	return bits.OnesCount64(x ^ y)
+}
@@ -4,66 +4,269 @@ document.querySelectorAll(".thumbnail").forEach(element => {
     })
 });
 
-function like(element) {
-    let uuid = element.parentElement.id
-    let likeCounter = element.parentElement.querySelector(`#likeCounter`)
-    let likeButton = element.parentElement.querySelector(`#like`)
+function react(element) {
+    let card = element.closest(".post-card")
+    let uuid = card.id
+    let likeCounter = card.querySelector(`#likeCounter`)
+    let current = card.querySelector(`.reactions .liked`)
 
     if (!window.signedIn) {
-        likeButton.innerText = "Sign in to like"
+        likeCounter.innerText = "Sign in to react"
         return
     }
 
     let count = Number(likeCounter.getAttribute('count'))
 
-    if (likeButton.classList.contains('liked')) {
-        fetch(`/removeLike/${uuid}`, {method: "POST"})
+    // one reaction per post, so whatever was picked before loses a count
+    if (current) {
+        current.classList.remove('liked')
+        bumpReaction(current, -1)
         count -= 1
-        likeCounter.innerText = `${count} likes`
-        likeButton.classList.remove('liked')
-        likeButton.innerText = "Like post"
+    }
+
+    if (current === element) {
+        fetch(`/removeLike/${uuid}`, {method: "POST"})
     } else {
-        fetch(`/likePost/${uuid}`, {method: "POST"})
+        fetch(`/likePost/${uuid}`, {method: "POST", body: new URLSearchParams({type: element.getAttribute('reaction')})})
+        element.classList.add('liked')
+        bumpReaction(element, 1)
         count += 1
-        likeCounter.innerText = `${count} likes`
-        likeButton.classList.add('liked')
-        likeButton.innerText = "Remove like"
     }
+
+    likeCounter.innerText = `${count} reactions`
     likeCounter.setAttribute('count', count.toString())
 }
 
+function bookmark(element) {
+    if (!window.signedIn) {
+        element.innerText = "Sign in to save"
+        return
+    }
+
+    let uuid = element.closest(".post-card").id
+
+    if (element.classList.contains('saved')) {
+        fetch(`/unbookmark/${uuid}`, {method: "POST"})
+        element.classList.remove('saved')
+        element.innerText = "Save"
+    } else {
+        fetch(`/bookmark/${uuid}`, {method: "POST"})
+        element.classList.add('saved')
+        element.innerText = "Saved"
+    }
+}
+
+function bumpReaction(button, by) {
+    let counter = button.querySelector('span')
+    let count = Number(counter.getAttribute('count')) + by
+    counter.innerText = count.toString()
+    counter.setAttribute('count', count.toString())
+}
+
+function follow(element) {
+    let uuid = element.parentElement.id
+    let followerCounter = element.parentElement.querySelector(`#followerCounter`)
+
+    let count = Number(followerCounter.getAttribute('count'))
+
+    if (element.classList.contains('followed')) {
+        fetch(`/unfollow/${uuid}`, {method: "POST"})
+        count -= 1
+        element.classList.remove('followed')
+        element.innerText = "Follow"
+    } else {
+        fetch(`/follow/${uuid}`, {method: "POST"})
+        count += 1
+        element.classList.add('followed')
+        element.innerText = "Unfollow"
+    }
+    followerCounter.innerText = `${count} followers`
+    followerCounter.setAttribute('count', count.toString())
+}
+
 function delPost(element, del) {
     let id
     if (del) {
+        // from the admin tables, the reason goes in the audit log
+        let reason = prompt("Reason for deleting this post?")
+        if (reason === null) {
+            return
+        }
         let row = element.parentElement.parentElement
         id = row.id
         row.parentElement.removeChild(row)
-        fetch(`/deletePost/${id}`, {method: "POST"})
+        fetch(`/deletePost/${id}`, {method: "POST", body: new URLSearchParams({reason: reason})})
     } else {
+        if (!confirm("Delete this post? You can restore it from your trash for a while.")) {
+            return
+        }
         id = element.parentElement.id
         fetch(`/deletePost/${id}`, {method: "POST"})
-        window.location = "/"
+            .then(() => window.location = "/")
     }
 }
 
 function delUser(element, del) {
     let id
     if (del) {
+        // from the admin tables, the reason goes in the audit log
+        let reason = prompt("Reason for deleting this user?")
+        if (reason === null) {
+            return
+        }
         let row = element.parentElement.parentElement
         id = row.id
         row.parentElement.removeChild(row)
-        fetch(`/deleteUser/${id}`, {method: "POST"})
+        fetch(`/deleteUser/${id}`, {method: "POST", body: new URLSearchParams({reason: reason})})
     } else {
+        if (!confirm("Delete your account? Signing back in within the restore window brings it back.")) {
+            return
+        }
         id = element.parentElement.id
         fetch(`/deleteUser/${id}`, {method: "POST"})
-        window.location = "/"
+            .then(() => window.location = "/")
     }
 
 }
 
 function deleteComment(element) {
+    if (!confirm("Delete this comment?")) {
+        return
+    }
     let box = element.parentElement
     
+    // comments with replies stay behind as [deleted], reload to show whichever happened
     fetch(`/deleteComment/${box.id}`, {method: "POST"})
+        .then(() => window.location.reload())
+}
+
+// plays the post's video from a comment's timestamp and boxes the region it points at
+function seek(element) {
+    let player = document.querySelector(".player")
+    if (!player) return
+
+    let video = player.querySelector("video")
+    let region = player.querySelector(".region")
+
+    video.currentTime = Number(element.getAttribute("seek"))
+    video.pause()
+
+    if (element.hasAttribute("region")) {
+        let [x, y, w, h] = element.getAttribute("region").split(",")
+        showRegion(region, x, y, w, h)
+    } else {
+        region.hidden = true
+    }
+
+    player.scrollIntoView({behavior: "smooth", block: "center"})
+}
+
+function showRegion(region, x, y, w, h) {
+    region.style.left = `${x}%`
+    region.style.top = `${y}%`
+    region.style.width = `${w}%`
+    region.style.height = `${h}%`
+    region.hidden = false
+}
+
+// fills a comment form's timestamp from the paused video, then lets you drag a box over the frame
+function markFrame(element) {
+    let player = document.querySelector(".player")
+    if (!player) return
+
+    let video = player.querySelector("video")
+    let region = player.querySelector(".region")
+    let form = element.closest("form")
+    let status = element.nextElementSibling
+
+    video.pause()
+    form.querySelector("[name=timestamp]").value = video.currentTime.toFixed(1)
+    form.querySelector("[name=region]").value = ""
+    region.hidden = true
+
+    player.classList.add("marking")
+    status.innerText = "Drag a box over the video, or click it to skip"
+    player.scrollIntoView({behavior: "smooth", block: "center"})
+
+    let percent = (event) => {
+        let rect = player.getBoundingClientRect()
+        return [
+            Math.min(Math.max((event.clientX - rect.left) / rect.width * 100, 0), 100),
+            Math.min(Math.max((event.clientY - rect.top) / rect.height * 100, 0), 100),
+        ]
+    }
+
+    let start = null
+    let box = () => [Math.min(start[0], start[2]), Math.min(start[1], start[3]), Math.abs(start[2] - start[0]), Math.abs(start[3] - start[1])]
+
+    let down = (event) => {
+        event.preventDefault()
+        start = percent(event).concat(percent(event))
+    }
+    let move = (event) => {
+        if (!start) return
+        [start[2], start[3]] = percent(event)
+        showRegion(region, ...box())
+    }
+    let up = () => {
+        player.classList.remove("marking")
+        player.removeEventListener("mousedown", down)
+        player.removeEventListener("mousemove", move)
+        player.removeEventListener("mouseup", up)
+
+        let [x, y, w, h] = start ? box() : [0, 0, 0, 0]
+        if (w >= 1 && h >= 1) {
+            form.querySelector("[name=region]").value = [x, y, w, h].map(n => n.toFixed(1)).join(",")
+            status.innerText = "Region marked"
+        } else {
+            region.hidden = true
+            status.innerText = ""
+        }
+    }
+
+    player.addEventListener("mousedown", down)
+    player.addEventListener("mousemove", move)
+    player.addEventListener("mouseup", up)
+}
+
+function readNotification(element) {
+    let box = element.parentElement
+    let body = new URLSearchParams()
+
+    // the header button has no type or target and marks everything read
+    if (box.hasAttribute("type")) {
+        body.set("type", box.getAttribute("type"))
+        body.set("target", box.getAttribute("target"))
+    }
+
+    fetch(`/notifications/read`, {method: "POST", body: body})
+        .then(() => window.location.reload())
+}
+
+function muteConversation(element) {
+    let uuid = element.parentElement.id
+    let muted = !element.classList.contains('followed')
+
+    fetch(`/messages/${uuid}/mute`, {method: "POST", body: new URLSearchParams({muted: muted})})
+    element.classList.toggle('followed')
+    element.innerText = muted ? "Unmute" : "Mute"
+}
+
+function deleteMessage(element) {
+    let box = element.parentElement
+
+    fetch(`/deleteMessage/${box.id}`, {method: "POST"})
     box.parentElement.removeChild(box)
+}
+
+function reportMessage(element) {
+    let box = element.parentElement
+    let reason = prompt("Why are you reporting this message?")
+    if (reason === null) {
+        return
+    }
+
+    fetch(`/reportMessage/${box.id}`, {method: "POST", body: new URLSearchParams({reason: reason})})
+    element.innerText = "Reported"
+    element.disabled = true
 }
\ No newline at end of file
@@ -0,0 +1,45 @@
+package main
+
+// replies nest this deep on the post page before we link to the rest of the thread
+const maxCommentDepth = 5
+
+// Hangs replies under their parents, stopping at maxCommentDepth
+//
+// viewer is the signed in user's UUID, moderator marks every comment as theirs to delete or restore,
+// post decides which comment is the accepted answer and who can pick one
+func threadComments(post Post, roots []Comment, replies []Comment, viewer string, moderator bool) []Comment {
+	children := make(map[string][]Comment)
+	for _, reply := range replies {
+		children[reply.ParentUUID] = append(children[reply.ParentUUID], reply)
+	}
+
+	var build func(comments []Comment, depth int) []Comment
+	build = func(comments []Comment, depth int) []Comment {
+		threaded := make([]Comment, 0, len(comments))
+		for _, comment := range comments {
+			comment.Owner = !comment.Deleted && viewer != "" && (comment.UserUUID == viewer || moderator)
+			comment.History = moderator && !comment.EditedAt.IsZero()
+			comment.Accepted = post.AcceptedUUID != "" && comment.UUID == post.AcceptedUUID
+			comment.CanAccept = post.FormCheck && !comment.Deleted && viewer != "" && viewer == post.UserUUID
+			comment.Video = post.IsVideo()
+			comment.CanRestore = comment.Deleted && comment.Content != "" && viewer != "" &&
+				(moderator || (comment.UserUUID == viewer && comment.DeletedBy == viewer))
+
+			if depth >= maxCommentDepth {
+				comment.Continues = len(children[comment.UUID]) > 0
+			} else {
+				comment.Replies = build(children[comment.UUID], depth+1)
+			}
+
+			// a deleted comment is only worth a placeholder while something under it is still up
+			if comment.Deleted && len(comment.Replies) == 0 && !comment.Continues {
+				continue
+			}
+
+			threaded = append(threaded, comment)
+		}
+		return threaded
+	}
+
+	return build(roots, 1)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Owners can take back their own deletes, moderators can take back anyone's, both only inside the window
+//
+// owner is who the content belongs to, by and at are who deleted it and when
+func canRestore(owner string, by string, at time.Time, viewer ApplicationState, retention time.Duration) bool {
+	if !viewer.SignedIn || time.Since(at) > retention {
+		return false
+	}
+
+	return viewer.Moderator || (viewer.UUID == owner && by == owner)
+}
+
+// When something deleted at will be purged, for telling people how long they have
+func restoreDeadline(at time.Time, retention time.Duration) time.Time {
+	return at.Add(retention)
+}
+
+// Hard deletes every post, comment and user that was deleted longer than retention ago
+//
+// returns how many of each went
+func (a App) purgeDeleted(retention time.Duration) (int, int, int, error) {
+	cutoff := time.Now().Add(-retention)
+
+	var posts []Post
+	err := a.DB.Table("Posts").Where("deleted = ? AND deleted_at < ?", true, cutoff).Find(&posts).Error
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, post := range posts {
+		if err := a.purgePost(post); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	// placeholders that were already emptied have nothing left to purge
+	var comments []Comment
+	err = a.DB.Table("Comments").Where("deleted = ? AND deleted_at < ? AND content <> ''", true, cutoff).Find(&comments).Error
+	if err != nil {
+		return len(posts), 0, 0, err
+	}
+	for _, comment := range comments {
+		if err := a.purgeComment(comment); err != nil {
+			return len(posts), 0, 0, err
+		}
+	}
+
+	var users []User
+	err = a.DB.Table("Users").Where("deleted = ? AND deleted_at < ?", true, cutoff).Find(&users).Error
+	if err != nil {
+		return len(posts), len(comments), 0, err
+	}
+	for _, user := range users {
+		if err := a.purgeUser(user); err != nil {
+			return len(posts), len(comments), 0, err
+		}
+	}
+
+	return len(posts), len(comments), len(users), nil
+}
+
+// Runs purgeDeleted on an interval for the life of the server, collectMedia then picks up the released media
+func (a App) purger(interval time.Duration, retention time.Duration) {
+	for {
+		posts, comments, users, err := a.purgeDeleted(retention)
+		if err != nil {
+			fmt.Println("Purging deleted content failed:", err)
+		} else if posts + comments + users > 0 {
+			fmt.Printf("Purged %d posts, %d comments and %d users\n", posts, comments, users)
+		}
+
+		time.Sleep(interval)
+	}
+}
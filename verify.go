@@ -0,0 +1,44 @@
+package main
+
+// approving votes needed before a lift shows as verified, the same number of objections rejects it
+const verificationVotes = 3
+
+// Verifiers can vote on anything, everyone else only on lifts tagged with a gym they belong to
+func (a App) canVerify(post Post, user User) bool {
+	if user.UUID == "" || user.UUID == post.UserUUID || post.Verification == "" {
+		return false
+	}
+	if user.Verifier {
+		return true
+	}
+	if post.GymUUID == "" {
+		return false
+	}
+
+	member, err := a.getGymMember(Gym{UUID: post.GymUUID}, user)
+	return err == nil && member.Approved
+}
+
+// Counts the votes and moves the post between pending, verified and rejected
+//
+// returns the new status so the caller can tell the author when it flips to verified
+func (a App) tallyVerification(post Post) (string, error) {
+	approvals, objections, err := a.countVerificationVotes(post)
+	if err != nil {
+		return post.Verification, err
+	}
+
+	status := "pending"
+	switch {
+	case approvals >= verificationVotes && approvals > objections:
+		status = "verified"
+	case objections >= verificationVotes && objections >= approvals:
+		status = "rejected"
+	}
+
+	if status == post.Verification {
+		return status, nil
+	}
+
+	return status, a.setVerification(post, status)
+}